@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const assertShortHelp = `Check the stats of an events stream against resource budgets.`
+
+var assertHelp = assertShortHelp + `
+
+Reads the runc events from stdin until the stream ends, or from a capture
+file, and checks the peak, average and percentiles of the metrics of every
+container against the budgets. Writes a JUnit XML report with a testcase for
+every budget and container and a Markdown summary, and exits with an error
+if a budget is exceeded.
+
+The budgets file is YAML or JSON:
+
+  budgets:
+    - name: web cpu
+      container: web-*
+      metric: cpu
+      max: 150%
+      avg: 50%
+    - container: "*"
+      metric: mem
+      p95: 512MiB
+
+The metrics are cpu, mem, mem_pct, net_rx, net_tx, block_read, block_write
+and pids, the limits are max, avg, p50, p95 and p99.`
+
+func (cmd *assertCommand) Name() string      { return "assert" }
+func (cmd *assertCommand) Args() string      { return "[OPTIONS] [CAPTURE]" }
+func (cmd *assertCommand) ShortHelp() string { return assertShortHelp }
+func (cmd *assertCommand) LongHelp() string  { return assertHelp }
+func (cmd *assertCommand) Hidden() bool      { return false }
+
+func (cmd *assertCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.budget, "budget", "", "file with the resource budgets")
+	fs.StringVar(&cmd.junit, "junit", "magneto-junit.xml", "file to write the JUnit XML report to")
+	fs.StringVar(&cmd.markdown, "markdown", "-", "file to write the Markdown summary to, - for stdout")
+}
+
+type assertCommand struct {
+	budget   string
+	junit    string
+	markdown string
+}
+
+// budgetMetrics maps the metrics of the budgets to the summary metrics.
+var budgetMetrics = map[string]string{
+	"cpu":         "cpu_percentage",
+	"mem":         "memory",
+	"mem_pct":     "memory_percentage",
+	"net_rx":      "network_rx",
+	"net_tx":      "network_tx",
+	"block_read":  "block_read",
+	"block_write": "block_write",
+	"pids":        "pids",
+}
+
+// budget is a limit on the distribution of a metric of the containers
+// matching a pattern.
+type budget struct {
+	Name      string `yaml:"name"`
+	Container string `yaml:"container"`
+	Metric    string `yaml:"metric"`
+	Max       string `yaml:"max"`
+	Avg       string `yaml:"avg"`
+	P50       string `yaml:"p50"`
+	P95       string `yaml:"p95"`
+	P99       string `yaml:"p99"`
+
+	limits []budgetLimit
+}
+
+// budgetLimit is a limit on a statistic of a metric.
+type budgetLimit struct {
+	stat  string
+	raw   string
+	value float64
+}
+
+// readBudgets reads the budgets from a YAML or JSON file.
+func readBudgets(file string) ([]*budget, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Budgets []*budget `yaml:"budgets"`
+	}
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing budgets file %s failed: %v", file, err)
+	}
+	if len(config.Budgets) == 0 {
+		return nil, fmt.Errorf("budgets file %s has no budgets", file)
+	}
+
+	for i, bg := range config.Budgets {
+		if err := bg.parse(); err != nil {
+			return nil, fmt.Errorf("invalid budget %d in %s: %v", i+1, file, err)
+		}
+	}
+	return config.Budgets, nil
+}
+
+// parse validates the budget and parses its limits.
+func (b *budget) parse() error {
+	if b.Container == "" {
+		b.Container = "*"
+	}
+	if _, err := path.Match(b.Container, ""); err != nil {
+		return fmt.Errorf("invalid container pattern %q: %v", b.Container, err)
+	}
+	if _, ok := budgetMetrics[b.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q, must be one of cpu, mem, mem_pct, net_rx, net_tx, block_read, block_write or pids", b.Metric)
+	}
+
+	for _, l := range []budgetLimit{
+		{stat: "max", raw: b.Max},
+		{stat: "avg", raw: b.Avg},
+		{stat: "p50", raw: b.P50},
+		{stat: "p95", raw: b.P95},
+		{stat: "p99", raw: b.P99},
+	} {
+		if l.raw == "" {
+			continue
+		}
+		v, err := parseRuleValue(l.raw, ruleMetrics[b.Metric].unit)
+		if err != nil {
+			return fmt.Errorf("invalid %s limit: %v", l.stat, err)
+		}
+		l.value = v
+		b.limits = append(b.limits, l)
+	}
+	if len(b.limits) == 0 {
+		return fmt.Errorf("budget for %s has no limits, set max, avg, p50, p95 or p99", b.Metric)
+	}
+
+	if b.Name == "" {
+		limits := make([]string, 0, len(b.limits))
+		for _, l := range b.limits {
+			limits = append(limits, l.stat+" "+l.raw)
+		}
+		b.Name = b.Metric + " " + strings.Join(limits, ", ")
+	}
+	return nil
+}
+
+// budgetCheck is the result of checking a statistic against a limit.
+type budgetCheck struct {
+	stat  string
+	value float64
+	limit budgetLimit
+	ok    bool
+}
+
+// budgetResult is the result of checking a budget for a container.
+type budgetResult struct {
+	budget    *budget
+	container string
+	duration  float64
+	checks    []budgetCheck
+	// failure is set when the budget was exceeded or could not be checked.
+	failure string
+}
+
+// checkBudgets checks the budgets against the summaries of the containers.
+// A budget that matches no container fails, so a renamed container does not
+// silently pass.
+func checkBudgets(budgets []*budget, summaries []containerSummary) []budgetResult {
+	var results []budgetResult
+	for _, b := range budgets {
+		matched := false
+		for _, summary := range summaries {
+			byID, _ := path.Match(b.Container, summary.ID)
+			byName, _ := path.Match(b.Container, summary.Name)
+			if !byID && !byName {
+				continue
+			}
+			matched = true
+			results = append(results, b.check(summary))
+		}
+		if !matched {
+			results = append(results, budgetResult{
+				budget:    b,
+				container: b.Container,
+				failure:   fmt.Sprintf("no container matched %q", b.Container),
+			})
+		}
+	}
+	return results
+}
+
+// check checks the budget for the container.
+func (b *budget) check(summary containerSummary) budgetResult {
+	r := budgetResult{
+		budget:    b,
+		container: summary.displayName(),
+		duration:  summary.Duration,
+	}
+
+	ms, ok := summary.Metrics[budgetMetrics[b.Metric]]
+	if !ok {
+		r.failure = fmt.Sprintf("no samples of %s for container %s", b.Metric, summary.displayName())
+		return r
+	}
+
+	var exceeded []string
+	for _, l := range b.limits {
+		var v float64
+		switch l.stat {
+		case "max":
+			v = ms.Max
+		case "avg":
+			v = ms.Avg
+		case "p50":
+			v = ms.P50
+		case "p95":
+			v = ms.P95
+		case "p99":
+			v = ms.P99
+		}
+		c := budgetCheck{stat: l.stat, value: v, limit: l, ok: v <= l.value}
+		r.checks = append(r.checks, c)
+		if !c.ok {
+			exceeded = append(exceeded, fmt.Sprintf("%s %s %s is above the budget of %s",
+				b.Metric, l.stat, formatCheckValue(b.Metric, v), l.raw))
+		}
+	}
+	r.failure = strings.Join(exceeded, ", ")
+	return r
+}
+
+func (cmd *assertCommand) Run(ctx context.Context, args []string) error {
+	if cmd.budget == "" {
+		return fmt.Errorf("pass the budgets file with --budget")
+	}
+	budgets, err := readBudgets(cmd.budget)
+	if err != nil {
+		return err
+	}
+
+	var s *stats
+	if len(args) > 0 {
+		_, records, err := readCapture(args[0])
+		if err != nil {
+			return err
+		}
+		s = newCaptureStats()
+		for _, r := range records {
+			s.process(r)
+		}
+	} else {
+		s = newStats()
+		if err := collectUntilDone(s); err != nil {
+			return err
+		}
+	}
+
+	results := checkBudgets(budgets, s.Summary())
+
+	if cmd.junit != "" {
+		if err := writeOutput(cmd.junit, func(w io.Writer) error {
+			return writeJUnit(w, results)
+		}); err != nil {
+			return err
+		}
+	}
+	if cmd.markdown != "" {
+		if err := writeOutput(cmd.markdown, func(w io.Writer) error {
+			return writeMarkdown(w, results)
+		}); err != nil {
+			return err
+		}
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.failure != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d budgets failed", failed, len(results))
+	}
+	return nil
+}
+
+// collectUntilDone collects the events from stdin until the stream ends or
+// the process is interrupted.
+func collectUntilDone(s *stats) error {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, syscall.SIGTERM)
+	defer signal.Stop(c)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.collect(newReaderSource("stdin", os.Stdin))
+	}()
+
+	select {
+	case sig := <-c:
+		logrus.Infof("Received %s, checking the budgets.", sig.String())
+		return nil
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("reading events failed: %v", err)
+		}
+		return nil
+	}
+}
+
+// writeOutput calls write with the file at path, or stdout if path is "-".
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type junitTestsuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Testsuites []junitTestsuite `xml:"testsuite"`
+}
+
+type junitTestsuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Testcases []junitTestcase `xml:"testcase"`
+}
+
+type junitTestcase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results as a JUnit XML report, with a testcase for
+// every budget and container.
+func writeJUnit(w io.Writer, results []budgetResult) error {
+	suite := junitTestsuite{
+		Name:      "magneto",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, r := range results {
+		tc := junitTestcase{
+			Name:      r.budget.Name,
+			Classname: "magneto." + r.container,
+			Time:      r.duration,
+		}
+		var lines []string
+		for _, c := range r.checks {
+			lines = append(lines, fmt.Sprintf("%s %s: %s (budget %s)",
+				r.budget.Metric, c.stat, formatCheckValue(r.budget.Metric, c.value), c.limit.raw))
+		}
+		tc.SystemOut = strings.Join(lines, "\n")
+		if r.failure != "" {
+			tc.Failure = &junitFailure{Message: r.failure, Type: "budget", Text: tc.SystemOut}
+			suite.Failures++
+		}
+		if r.duration > suite.Time {
+			suite.Time = r.duration
+		}
+		suite.Testcases = append(suite.Testcases, tc)
+	}
+	suite.Tests = len(suite.Testcases)
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestsuites{Testsuites: []junitTestsuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeMarkdown writes the results as a Markdown table, with a row for every
+// limit checked.
+func writeMarkdown(w io.Writer, results []budgetResult) error {
+	var failed int
+	for _, r := range results {
+		if r.failure != "" {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(w, "## Resource budgets\n\n")
+	if failed > 0 {
+		fmt.Fprintf(w, "**%d of %d budgets failed.**\n\n", failed, len(results))
+	} else {
+		fmt.Fprintf(w, "All %d budgets passed.\n\n", len(results))
+	}
+
+	fmt.Fprintf(w, "| Container | Budget | Metric | Value | Limit | Result |\n")
+	fmt.Fprintf(w, "| --- | --- | --- | --- | --- | --- |\n")
+	for _, r := range results {
+		if len(r.checks) == 0 {
+			fmt.Fprintf(w, "| %s | %s | %s | | | FAIL: %s |\n",
+				markdownEscape(r.container), markdownEscape(r.budget.Name), r.budget.Metric, markdownEscape(r.failure))
+			continue
+		}
+		for _, c := range r.checks {
+			result := "pass"
+			if !c.ok {
+				result = "**FAIL**"
+			}
+			fmt.Fprintf(w, "| %s | %s | %s %s | %s | %s | %s |\n",
+				markdownEscape(r.container), markdownEscape(r.budget.Name),
+				r.budget.Metric, c.stat,
+				formatCheckValue(r.budget.Metric, c.value), c.limit.raw,
+				result)
+		}
+	}
+	return nil
+}
+
+// markdownEscape escapes the characters that would break a table cell.
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_").Replace(s)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBudgets(t *testing.T) {
+	testCases := []struct {
+		name        string
+		content     string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name: "yaml",
+			content: `budgets:
+  - name: web cpu
+    container: web-*
+    metric: cpu
+    max: 150%
+    avg: 50%
+  - metric: mem
+    p95: 512MiB
+`,
+			expected: []string{"web cpu web-* cpu max=150 avg=50", "mem p95 512MiB * mem p95=536870912"},
+		},
+		{
+			name:     "json",
+			content:  `{"budgets": [{"container": "db", "metric": "pids", "max": "100"}]}`,
+			expected: []string{"pids max 100 db pids max=100"},
+		},
+		{
+			name:        "invalid yaml",
+			content:     "budgets: [",
+			expectedErr: "parsing budgets file FILE failed: ",
+		},
+		{
+			name:        "unknown field",
+			content:     "budgets:\n  - metric: cpu\n    max: 50%\n    min: 10%\n",
+			expectedErr: "parsing budgets file FILE failed: ",
+		},
+		{
+			name:        "no budgets",
+			content:     "budgets: []\n",
+			expectedErr: "budgets file FILE has no budgets",
+		},
+		{
+			name:        "unknown metric",
+			content:     "budgets:\n  - metric: gpu\n    max: 50%\n",
+			expectedErr: `invalid budget 1 in FILE: unknown metric "gpu", must be one of cpu, mem, mem_pct, net_rx, net_tx, block_read, block_write or pids`,
+		},
+		{
+			name:        "invalid container pattern",
+			content:     "budgets:\n  - metric: cpu\n    max: 50%\n  - container: \"web-[\"\n    metric: cpu\n    max: 50%\n",
+			expectedErr: `invalid budget 2 in FILE: invalid container pattern "web-[": syntax error in pattern`,
+		},
+		{
+			name:        "no limits",
+			content:     "budgets:\n  - metric: cpu\n",
+			expectedErr: "invalid budget 1 in FILE: budget for cpu has no limits, set max, avg, p50, p95 or p99",
+		},
+		{
+			name:        "invalid limit",
+			content:     "budgets:\n  - metric: cpu\n    p99: lots\n",
+			expectedErr: "invalid budget 1 in FILE: invalid p99 limit: invalid value lots",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "magneto-budgets")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tc.content); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			budgets, err := readBudgets(f.Name())
+			if tc.expectedErr != "" {
+				expectedErr := strings.Replace(tc.expectedErr, "FILE", f.Name(), -1)
+				if err == nil || !strings.HasPrefix(err.Error(), expectedErr) {
+					t.Fatalf("expected error %q, got %v", expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, b := range budgets {
+				s := b.Name + " " + b.Container + " " + b.Metric
+				for _, l := range b.limits {
+					s += " " + l.stat + "=" + formatPerfdataValue(l.value)
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected budgets %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// testBudget returns a parsed budget, failing the test if it is invalid.
+func testBudget(t *testing.T, b budget) *budget {
+	if err := b.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return &b
+}
+
+func TestCheckBudgets(t *testing.T) {
+	summaries := []containerSummary{
+		{
+			ID: "abc", Name: "web-1", Samples: 10, Duration: 9,
+			Metrics: map[string]metricSummary{
+				"cpu_percentage": {Min: 5, Avg: 40, Max: 120, P50: 35, P95: 100, P99: 110},
+				"memory":         {Min: 1 << 20, Avg: 2 << 20, Max: 4 << 20, P50: 2 << 20, P95: 3 << 20, P99: 4 << 20},
+			},
+		},
+		{
+			ID: "def", Samples: 10, Duration: 12,
+			Metrics: map[string]metricSummary{
+				"cpu_percentage": {Min: 50, Avg: 80, Max: 200, P50: 75, P95: 180, P99: 195},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		budget   budget
+		expected []string
+	}{
+		{
+			name:     "max passes",
+			budget:   budget{Container: "web-*", Metric: "cpu", Max: "150%"},
+			expected: []string{"web-1: max=120 ok"},
+		},
+		{
+			name:     "max fails",
+			budget:   budget{Container: "def", Metric: "cpu", Max: "150%"},
+			expected: []string{"def: max=200 FAIL -> cpu max 200.00% is above the budget of 150%"},
+		},
+		{
+			name:     "avg",
+			budget:   budget{Metric: "cpu", Avg: "50%"},
+			expected: []string{"web-1: avg=40 ok", "def: avg=80 FAIL -> cpu avg 80.00% is above the budget of 50%"},
+		},
+		{
+			name:     "percentiles",
+			budget:   budget{Container: "abc", Metric: "cpu", P50: "40%", P95: "90%", P99: "120%"},
+			expected: []string{"web-1: p50=35 ok p95=100 FAIL p99=110 ok -> cpu p95 100.00% is above the budget of 90%"},
+		},
+		{
+			name:     "bytes",
+			budget:   budget{Container: "web-1", Metric: "mem", Max: "4MiB", P95: "2MiB"},
+			expected: []string{"web-1: max=4194304 ok p95=3145728 FAIL -> mem p95 3MiB is above the budget of 2MiB"},
+		},
+		{
+			name:     "no samples",
+			budget:   budget{Container: "def", Metric: "mem", Max: "1GiB"},
+			expected: []string{"def: -> no samples of mem for container def"},
+		},
+		{
+			name:     "no container",
+			budget:   budget{Container: "db-*", Metric: "cpu", Max: "100%"},
+			expected: []string{`db-*: -> no container matched "db-*"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			results := checkBudgets([]*budget{testBudget(t, tc.budget)}, summaries)
+
+			var got []string
+			for _, r := range results {
+				s := r.container + ":"
+				for _, c := range r.checks {
+					result := "ok"
+					if !c.ok {
+						result = "FAIL"
+					}
+					s += " " + c.stat + "=" + formatPerfdataValue(c.value) + " " + result
+				}
+				if r.failure != "" {
+					s += " -> " + r.failure
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected results %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// testBudgetResults are a passed, a failed and an unmatched budget.
+func testBudgetResults(t *testing.T) []budgetResult {
+	summaries := []containerSummary{{
+		ID: "abc", Name: "web_1", Duration: 9,
+		Metrics: map[string]metricSummary{
+			"cpu_percentage": {Avg: 40, Max: 120},
+		},
+	}}
+	return checkBudgets([]*budget{
+		testBudget(t, budget{Name: "web cpu", Container: "web*", Metric: "cpu", Max: "150%"}),
+		testBudget(t, budget{Container: "web*", Metric: "cpu", Max: "100%", Avg: "50%"}),
+		testBudget(t, budget{Container: "db|x", Metric: "pids", Max: "10"}),
+	}, summaries)
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, testBudgetResults(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Fatalf("expected the XML header, got %q", buf.String())
+	}
+
+	var report junitTestsuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %s: %v", buf.String(), err)
+	}
+	if len(report.Testsuites) != 1 {
+		t.Fatalf("expected one testsuite, got %d", len(report.Testsuites))
+	}
+	suite := report.Testsuites[0]
+	if suite.Name != "magneto" || suite.Tests != 3 || suite.Failures != 2 || suite.Time != 9 || suite.Timestamp == "" {
+		t.Fatalf("unexpected testsuite %s tests=%d failures=%d time=%v timestamp=%q", suite.Name, suite.Tests, suite.Failures, suite.Time, suite.Timestamp)
+	}
+
+	expected := []junitTestcase{
+		{
+			Name:      "web cpu",
+			Classname: "magneto.web_1",
+			Time:      9,
+			SystemOut: "cpu max: 120.00% (budget 150%)",
+		},
+		{
+			Name:      "cpu max 100%, avg 50%",
+			Classname: "magneto.web_1",
+			Time:      9,
+			Failure: &junitFailure{
+				Message: "cpu max 120.00% is above the budget of 100%",
+				Type:    "budget",
+				Text:    "cpu max: 120.00% (budget 100%)\ncpu avg: 40.00% (budget 50%)",
+			},
+			SystemOut: "cpu max: 120.00% (budget 100%)\ncpu avg: 40.00% (budget 50%)",
+		},
+		{
+			Name:      "pids max 10",
+			Classname: "magneto.db|x",
+			Failure: &junitFailure{
+				Message: `no container matched "db|x"`,
+				Type:    "budget",
+			},
+		},
+	}
+	if !reflect.DeepEqual(suite.Testcases, expected) {
+		t.Fatalf("expected testcases %+v, got %+v", expected, suite.Testcases)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMarkdown(&buf, testBudgetResults(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `## Resource budgets
+
+**2 of 3 budgets failed.**
+
+| Container | Budget | Metric | Value | Limit | Result |
+| --- | --- | --- | --- | --- | --- |
+| web\_1 | web cpu | cpu max | 120.00% | 150% | pass |
+| web\_1 | cpu max 100%, avg 50% | cpu max | 120.00% | 100% | **FAIL** |
+| web\_1 | cpu max 100%, avg 50% | cpu avg | 40.00% | 50% | pass |
+| db\|x | pids max 10 | pids | | | FAIL: no container matched "db\|x" |
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeMarkdown(&buf, testBudgetResults(t)[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "All 1 budgets passed.") {
+		t.Fatalf("expected all budgets to pass, got\n%s", buf.String())
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// blkioDetail holds the block I/O statistics derived from the blkio
+// cgroup recursive counters.
+type blkioDetail struct {
+	// ServiceLatency is the average time in nanoseconds between dispatch and
+	// completion of the I/Os completed between two samples.
+	ServiceLatency float64 `json:"service_latency_ns"`
+	// WaitLatency is the average time in nanoseconds the I/Os completed
+	// between two samples spent waiting in the scheduler queues.
+	WaitLatency float64 `json:"wait_latency_ns"`
+	// QueueDepth is the number of I/Os currently queued.
+	QueueDepth uint64 `json:"queue_depth"`
+	// MergePercentage is the percentage of the I/Os between two samples
+	// that were merged into other requests.
+	MergePercentage float64 `json:"merge_percentage"`
+	// Utilization is the percentage of time the busiest device spent
+	// servicing I/O for the container between two samples.
+	Utilization float64 `json:"utilization"`
+}
+
+// blkioCounters are the cumulative blkio counters the latencies and the
+// merge percentage are calculated from.
+type blkioCounters struct {
+	serviced    uint64
+	merged      uint64
+	serviceTime uint64
+	waitTime    uint64
+}
+
+// blkioTracker keeps the counters and the device time of the previous
+// sample so the statistics can be calculated between readings.
+type blkioTracker struct {
+	previous     *blkioCounters
+	previousTime map[string]uint64
+	previousRead time.Time
+}
+
+// sumBlkioReadWrite sums the values of the read and write entries. The
+// sync, async and total entries are skipped since they duplicate them.
+func sumBlkioReadWrite(entries []types.BlkioEntry) uint64 {
+	var total uint64
+	for _, entry := range entries {
+		switch strings.ToLower(entry.Op) {
+		case "read", "write":
+			total = total + entry.Value
+		}
+	}
+	return total
+}
+
+// calculateBlockIODetail returns the block I/O latency, queue depth, merge
+// percentage and utilization for the sample read at now. The latencies and
+// the merge percentage are calculated from the I/Os since the previous
+// sample, so they are 0 on the first sample and when the counters reset.
+func (t *blkioTracker) calculateBlockIODetail(blkio types.Blkio, now time.Time) blkioDetail {
+	var d blkioDetail
+
+	current := &blkioCounters{
+		serviced:    sumBlkioReadWrite(blkio.IoServicedRecursive),
+		merged:      sumBlkioReadWrite(blkio.IoMergedRecursive),
+		serviceTime: sumBlkioReadWrite(blkio.IoServiceTimeRecursive),
+		waitTime:    sumBlkioReadWrite(blkio.IoWaitTimeRecursive),
+	}
+	if p := t.previous; p != nil && current.serviced >= p.serviced && current.merged >= p.merged &&
+		current.serviceTime >= p.serviceTime && current.waitTime >= p.waitTime {
+		serviced := float64(current.serviced - p.serviced)
+		merged := float64(current.merged - p.merged)
+		if serviced > 0 {
+			d.ServiceLatency = float64(current.serviceTime-p.serviceTime) / serviced
+			d.WaitLatency = float64(current.waitTime-p.waitTime) / serviced
+		}
+		if serviced+merged > 0 {
+			d.MergePercentage = merged / (serviced + merged) * 100.0
+		}
+	}
+	t.previous = current
+
+	d.QueueDepth = sumBlkioReadWrite(blkio.IoQueuedRecursive)
+
+	d.Utilization = t.calculateUtilization(blkio.IoTimeRecursive, now)
+
+	return d
+}
+
+// calculateUtilization returns the utilization of the busiest device. The
+// io_time counters are reported in milliseconds per device.
+func (t *blkioTracker) calculateUtilization(entries []types.BlkioEntry, now time.Time) float64 {
+	var (
+		utilization float64
+		current     = make(map[string]uint64, len(entries))
+		elapsed     = now.Sub(t.previousRead)
+	)
+
+	for _, entry := range entries {
+		device := fmt.Sprintf("%d:%d", entry.Major, entry.Minor)
+		current[device] = current[device] + entry.Value
+	}
+
+	if t.previousTime != nil && elapsed > 0 {
+		for device, v := range current {
+			previous, ok := t.previousTime[device]
+			if !ok || v < previous {
+				continue
+			}
+			busy := time.Duration(v-previous) * time.Millisecond
+			if u := float64(busy) / float64(elapsed) * 100.0; u > utilization {
+				utilization = u
+			}
+		}
+	}
+
+	t.previousTime = current
+	t.previousRead = now
+
+	if utilization > 100.0 {
+		utilization = 100.0
+	}
+	return utilization
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func blkioSample(serviced, merged, serviceTime, waitTime, ioTime uint64) types.Blkio {
+	entries := func(v uint64) []types.BlkioEntry {
+		return []types.BlkioEntry{
+			{Major: 8, Op: "Read", Value: v / 2},
+			{Major: 8, Op: "Write", Value: v - v/2},
+			{Major: 8, Op: "Total", Value: v},
+		}
+	}
+	return types.Blkio{
+		IoServicedRecursive:    entries(serviced),
+		IoMergedRecursive:      entries(merged),
+		IoServiceTimeRecursive: entries(serviceTime),
+		IoWaitTimeRecursive:    entries(waitTime),
+		IoTimeRecursive:        []types.BlkioEntry{{Major: 8, Value: ioTime}},
+		IoQueuedRecursive:      []types.BlkioEntry{{Major: 8, Op: "Read", Value: 3}},
+	}
+}
+
+func TestCalculateBlockIODetail(t *testing.T) {
+	start := time.Unix(1000, 0)
+	testCases := []struct {
+		name    string
+		samples []types.Blkio
+		want    blkioDetail
+	}{
+		{
+			name:    "first sample",
+			samples: []types.Blkio{blkioSample(100, 10, 1e9, 2e9, 0)},
+			want:    blkioDetail{QueueDepth: 3},
+		},
+		{
+			name: "latency between samples",
+			samples: []types.Blkio{
+				// 1ms per I/O since the cgroup was created.
+				blkioSample(1000, 0, 1e9, 1e9, 0),
+				// 10ms per I/O for the last 100 I/Os.
+				blkioSample(1100, 100, 2e9, 1.5e9, 500),
+			},
+			want: blkioDetail{
+				ServiceLatency:  1e7,
+				WaitLatency:     5e6,
+				QueueDepth:      3,
+				MergePercentage: 50,
+				Utilization:     50,
+			},
+		},
+		{
+			name: "no I/O between samples",
+			samples: []types.Blkio{
+				blkioSample(1000, 10, 1e9, 1e9, 100),
+				blkioSample(1000, 10, 1e9, 1e9, 100),
+			},
+			want: blkioDetail{QueueDepth: 3},
+		},
+		{
+			name: "counter reset",
+			samples: []types.Blkio{
+				blkioSample(1000, 10, 1e9, 1e9, 100),
+				blkioSample(10, 0, 1e6, 1e6, 0),
+			},
+			want: blkioDetail{QueueDepth: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := &blkioTracker{}
+			var got blkioDetail
+			for i, sample := range tc.samples {
+				got = tracker.calculateBlockIODetail(sample, start.Add(time.Duration(i)*time.Second))
+			}
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
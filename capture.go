@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captureVersion is the version of the capture file format. It is increased
+// when a change to the format would break reading older captures.
+const captureVersion = 1
+
+// captureHeader is the first line of a capture file. It describes the host
+// the events were recorded on, since the system cpu usage depends on it.
+type captureHeader struct {
+	Version    int       `json:"magneto_capture"`
+	Created    time.Time `json:"created"`
+	Hostname   string    `json:"hostname,omitempty"`
+	ClockTicks uint64    `json:"clock_ticks"`
+	NumCPU     int       `json:"num_cpu"`
+}
+
+// captureRecord is a line in a capture file holding a single event along with
+// when and where it was received.
+type captureRecord struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// SystemCPUUsage is the host system's cpu usage in nanoseconds when the
+	// event was received, it is only set for stats events.
+	SystemCPUUsage uint64 `json:"system_cpu_usage,omitempty"`
+	Event          event  `json:"event"`
+}
+
+// captureWriter writes events to a capture file. Files ending in ".gz" are
+// gzip compressed.
+type captureWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newCaptureWriter(path string, clockTicks uint64) (*captureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &captureWriter{f: f}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.enc = json.NewEncoder(out)
+
+	hostname, _ := os.Hostname()
+	if err := w.enc.Encode(captureHeader{
+		Version:    captureVersion,
+		Created:    time.Now(),
+		Hostname:   hostname,
+		ClockTicks: clockTicks,
+		NumCPU:     runtime.NumCPU(),
+	}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing capture header to %s failed: %v", path, err)
+	}
+
+	return w, nil
+}
+
+// Write appends a record to the capture file.
+func (w *captureWriter) Write(r captureRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.enc == nil {
+		return fmt.Errorf("capture file %s is closed", w.f.Name())
+	}
+	return w.enc.Encode(r)
+}
+
+// Close flushes and closes the capture file.
+func (w *captureWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.enc == nil {
+		return nil
+	}
+	w.enc = nil
+
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
+
+// readCapture reads the header and all the records of a capture file. Gzip
+// compressed captures are detected from their contents.
+func readCapture(path string) (captureHeader, []captureRecord, error) {
+	var header captureHeader
+
+	f, err := os.Open(path)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var in io.Reader = br
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return header, nil, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	dec := json.NewDecoder(in)
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("reading capture header from %s failed: %v", path, err)
+	}
+	if header.Version < 1 || header.Version > captureVersion {
+		return header, nil, fmt.Errorf("%s is not a capture file with a supported version, got version %d", path, header.Version)
+	}
+
+	var records []captureRecord
+	for {
+		var r captureRecord
+		if err := dec.Decode(&r); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return header, nil, fmt.Errorf("reading capture record %d from %s failed: %v", len(records)+1, path, err)
+		}
+		records = append(records, r)
+	}
+
+	return header, records, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+	"github.com/sirupsen/logrus"
+)
+
+// cgroupIDPrefixes are the prefixes the runtimes put before the container id
+// in the names of the systemd scopes of the containers.
+var cgroupIDPrefixes = []string{"docker-", "cri-containerd-", "crio-", "libpod-"}
+
+// cgroupSource polls the files of cgroup v2 directories at the refresh
+// interval, for containers whose runtime does not send stats events. The
+// pattern is matched again on every poll, so containers that start later are
+// picked up.
+type cgroupSource struct {
+	name    string
+	pattern string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	ticker  *time.Ticker
+
+	// pending are the events of the last poll not returned yet.
+	pending   []event
+	malformed uint64
+}
+
+// newCgroupSource polls the cgroup directories matching the pattern, like
+// /sys/fs/cgroup/system.slice/docker-*.scope.
+func newCgroupSource(name, pattern string) (*cgroupSource, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &cgroupSource{
+		name:    name,
+		pattern: pattern,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	// Fail early if nothing can be read.
+	if err := s.poll(); err != nil {
+		cancel()
+		return nil, err
+	}
+	s.ticker = time.NewTicker(interval)
+	return s, nil
+}
+
+func (s *cgroupSource) Name() string      { return s.name }
+func (s *cgroupSource) Malformed() uint64 { return s.malformed }
+
+// Read returns the events of the last poll, polling again at the refresh
+// interval. A failed poll is logged and retried.
+func (s *cgroupSource) Read() (event, error) {
+	for len(s.pending) == 0 {
+		select {
+		case <-s.ticker.C:
+		case <-s.ctx.Done():
+			return event{}, io.EOF
+		}
+		if err := s.poll(); err != nil {
+			logrus.Warn(err)
+		}
+	}
+
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	return e, nil
+}
+
+// Close stops polling.
+func (s *cgroupSource) Close() error {
+	s.cancel()
+	return nil
+}
+
+// poll reads the cgroups matching the pattern and queues their stats. A
+// cgroup that cannot be read, for example since its container just exited,
+// is skipped.
+func (s *cgroupSource) poll() error {
+	dirs, err := filepath.Glob(s.pattern)
+	if err != nil {
+		return fmt.Errorf("invalid cgroup pattern %s: %v", s.pattern, err)
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no cgroup matches %s", s.pattern)
+	}
+
+	for _, dir := range dirs {
+		v, err := readCgroupStats(dir)
+		if err != nil {
+			logrus.Debugf("reading cgroup %s failed: %v", dir, err)
+			if !os.IsNotExist(err) {
+				s.malformed++
+			}
+			continue
+		}
+		s.pending = append(s.pending, event{Type: "stats", ID: cgroupContainerID(dir), Data: v})
+	}
+	return nil
+}
+
+// cgroupContainerID returns the id of the container of a cgroup directory,
+// its name without the runtime prefix and the .scope suffix of systemd.
+func cgroupContainerID(dir string) string {
+	id := strings.TrimSuffix(filepath.Base(dir), ".scope")
+	for _, prefix := range cgroupIDPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return strings.TrimPrefix(id, prefix)
+		}
+	}
+	return id
+}
+
+// readCgroupStats reads the stats of a cgroup v2 directory into runc stats.
+// The files of the controllers that are not enabled are skipped, the
+// inactive file memory is used like the page cache of cgroup v1.
+func readCgroupStats(dir string) (types.Stats, error) {
+	var v types.Stats
+
+	cpu, err := readCgroupKeyedFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return v, err
+	}
+	v.CPU.Usage.Total = cpu["usage_usec"] * 1000
+	v.CPU.Usage.User = cpu["user_usec"] * 1000
+	v.CPU.Usage.Kernel = cpu["system_usec"] * 1000
+	// The cgroup is on this host, the cpu percentage is scaled by its cpus.
+	v.CPU.Usage.Percpu = make([]uint64, runtime.NumCPU())
+	v.CPU.Throttling = types.Throttling{
+		Periods:          cpu["nr_periods"],
+		ThrottledPeriods: cpu["nr_throttled"],
+		ThrottledTime:    cpu["throttled_usec"] * 1000,
+	}
+
+	if v.Memory.Usage.Usage, err = readCgroupValue(filepath.Join(dir, "memory.current")); err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+	if v.Memory.Usage.Limit, err = readCgroupValue(filepath.Join(dir, "memory.max")); err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+	memory, err := readCgroupKeyedFile(filepath.Join(dir, "memory.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+	if cache := memory["inactive_file"]; cache <= v.Memory.Usage.Usage {
+		v.Memory.Cache = cache
+	}
+
+	if v.Pids.Current, err = readCgroupValue(filepath.Join(dir, "pids.current")); err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+	if v.Pids.Limit, err = readCgroupValue(filepath.Join(dir, "pids.max")); err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+
+	if v.Blkio, err = readCgroupIOStat(filepath.Join(dir, "io.stat")); err != nil && !os.IsNotExist(err) {
+		return v, err
+	}
+	return v, nil
+}
+
+// readCgroupValue reads a file holding a single number, 0 for "max".
+func readCgroupValue(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s failed: %v", path, err)
+	}
+	return v, nil
+}
+
+// readCgroupKeyedFile reads a file with a key and a number on every line,
+// like cpu.stat.
+func readCgroupKeyedFile(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %v", path, err)
+		}
+		values[fields[0]] = v
+	}
+	return values, sc.Err()
+}
+
+// readCgroupIOStat reads io.stat, with a line per device like
+// "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0", into the bytes and
+// operations of the blkio stats.
+func readCgroupIOStat(path string) (types.Blkio, error) {
+	var blkio types.Blkio
+
+	f, err := os.Open(path)
+	if err != nil {
+		return blkio, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var major, minor uint64
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &major, &minor); err != nil {
+			return blkio, fmt.Errorf("parsing %s failed: invalid device %s", path, fields[0])
+		}
+		entry := func(op string, v uint64) types.BlkioEntry {
+			return types.BlkioEntry{Major: major, Minor: minor, Op: op, Value: v}
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return blkio, fmt.Errorf("parsing %s failed: %v", path, err)
+			}
+			switch kv[0] {
+			case "rbytes":
+				blkio.IoServiceBytesRecursive = append(blkio.IoServiceBytesRecursive, entry("Read", v))
+			case "wbytes":
+				blkio.IoServiceBytesRecursive = append(blkio.IoServiceBytesRecursive, entry("Write", v))
+			case "rios":
+				blkio.IoServicedRecursive = append(blkio.IoServicedRecursive, entry("Read", v))
+			case "wios":
+				blkio.IoServicedRecursive = append(blkio.IoServicedRecursive, entry("Write", v))
+			}
+		}
+	}
+	return blkio, sc.Err()
+}
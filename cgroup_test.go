@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// writeCgroupTree writes the files of fake cgroup directories.
+func writeCgroupTree(t *testing.T, cgroups map[string]map[string]string) string {
+	dir, err := ioutil.TempDir("", "magneto-cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, files := range cgroups {
+		d := filepath.Join(dir, name)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(d, file), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestReadCgroupStats(t *testing.T) {
+	testCases := []struct {
+		name    string
+		files   map[string]string
+		want    types.Stats
+		wantErr bool
+	}{
+		{
+			name: "all controllers",
+			files: map[string]string{
+				"cpu.stat":       "usage_usec 2000\nuser_usec 1500\nsystem_usec 500\nnr_periods 10\nnr_throttled 2\nthrottled_usec 300\n",
+				"memory.current": "4096\n",
+				"memory.max":     "max\n",
+				"memory.stat":    "anon 1024\nfile 3072\ninactive_file 1000\n",
+				"pids.current":   "3\n",
+				"pids.max":       "100\n",
+				"io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n",
+			},
+			want: types.Stats{
+				CPU: types.CPU{
+					Usage:      types.CPUUsage{Total: 2000000, User: 1500000, Kernel: 500000, Percpu: make([]uint64, runtime.NumCPU())},
+					Throttling: types.Throttling{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 300000},
+				},
+				Memory: types.Memory{Cache: 1000, Usage: types.MemoryEntry{Usage: 4096}},
+				Pids:   types.Pids{Current: 3, Limit: 100},
+				Blkio: types.Blkio{
+					IoServiceBytesRecursive: []types.BlkioEntry{{Major: 8, Op: "Read", Value: 100}, {Major: 8, Op: "Write", Value: 200}},
+					IoServicedRecursive:     []types.BlkioEntry{{Major: 8, Op: "Read", Value: 1}, {Major: 8, Op: "Write", Value: 2}},
+				},
+			},
+		},
+		{
+			name:  "only cpu",
+			files: map[string]string{"cpu.stat": "usage_usec 1\n"},
+			want: types.Stats{
+				CPU: types.CPU{Usage: types.CPUUsage{Total: 1000, Percpu: make([]uint64, runtime.NumCPU())}},
+			},
+		},
+		{
+			name:    "no cpu.stat",
+			files:   map[string]string{"memory.current": "1\n"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed value",
+			files:   map[string]string{"cpu.stat": "usage_usec 1\n", "pids.current": "many\n"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed device",
+			files:   map[string]string{"cpu.stat": "usage_usec 1\n", "io.stat": "sda rbytes=1\n"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeCgroupTree(t, map[string]map[string]string{"c": tc.files})
+			defer os.RemoveAll(dir)
+
+			got, err := readCgroupStats(filepath.Join(dir, "c"))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCgroupContainerID(t *testing.T) {
+	testCases := map[string]string{
+		"/sys/fs/cgroup/system.slice/docker-abc.scope":                     "abc",
+		"/sys/fs/cgroup/kubepods.slice/pod1/cri-containerd-def.scope":      "def",
+		"/sys/fs/cgroup/machine.slice/libpod-0123.scope":                   "0123",
+		"/sys/fs/cgroup/kubepods/burstable/pod1/crio-456.scope":            "456",
+		"/sys/fs/cgroup/mycontainer":                                       "mycontainer",
+		"/sys/fs/cgroup/system.slice/containerd.service/k8s.io/abc.scope/": "abc",
+	}
+
+	for dir, want := range testCases {
+		if got := cgroupContainerID(dir); got != want {
+			t.Errorf("expected %s for %s, got %s", want, dir, got)
+		}
+	}
+}
+
+func TestCgroupSourcePoll(t *testing.T) {
+	dir := writeCgroupTree(t, map[string]map[string]string{
+		"docker-abc.scope": {"cpu.stat": "usage_usec 1\n"},
+		"docker-def.scope": {"cpu.stat": "usage_usec 2\n"},
+		"docker-bad.scope": {"cpu.stat": "usage_usec x\n"},
+		"other":            {"cpu.stat": "usage_usec 3\n"},
+	})
+	defer os.RemoveAll(dir)
+
+	s := &cgroupSource{pattern: filepath.Join(dir, "docker-*.scope")}
+	if err := s.poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, e := range s.pending {
+		ids = append(ids, e.ID)
+	}
+	if want := []string{"abc", "def"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected the events of %v, got %v", want, ids)
+	}
+	if s.malformed != 1 {
+		t.Fatalf("expected 1 malformed cgroup, got %d", s.malformed)
+	}
+
+	s.pattern = filepath.Join(dir, "podman-*")
+	if err := s.poll(); err == nil {
+		t.Fatal("expected an error when no cgroup matches")
+	}
+}
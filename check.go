@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+)
+
+// The exit codes of the check command, from the monitoring plugins guidelines.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatuses = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkPerfdata are the metrics always included in the performance data.
+var checkPerfdata = []string{"cpu", "mem", "mem_pct", "pids"}
+
+const checkShortHelp = `Check a container like a Nagios or Icinga plugin.`
+
+var checkHelp = checkShortHelp + `
+
+Collects the stats of the container with runc events for a short window,
+prints a status line with performance data and exits with 0, 1, 2 or 3 for
+OK, WARNING, CRITICAL or UNKNOWN. The thresholds are passed as metric=range
+with the same metrics as the rules, where the range is in the plugin format:
+
+  80       alert if above 80
+  10:      alert if below 10
+  ~:80     alert if above 80
+  10:80    alert if outside 10 to 80
+  @10:80   alert if inside 10 to 80
+
+For example:
+
+  magneto check <container_id> --warn cpu=80 --crit cpu=95 --warn mem=70`
+
+func (cmd *checkCommand) Name() string      { return "check" }
+func (cmd *checkCommand) Args() string      { return "[OPTIONS] CONTAINER" }
+func (cmd *checkCommand) ShortHelp() string { return checkShortHelp }
+func (cmd *checkCommand) LongHelp() string  { return checkHelp }
+func (cmd *checkCommand) Hidden() bool      { return false }
+
+func (cmd *checkCommand) Register(fs *flag.FlagSet) {
+	cmd.fs = fs
+	fs.Var(&cmd.warn, "warn", "warning threshold as metric=range, can be passed multiple times")
+	fs.Var(&cmd.crit, "crit", "critical threshold as metric=range, can be passed multiple times")
+	fs.DurationVar(&cmd.window, "window", 5*time.Second, "how long to collect the stats for")
+	fs.DurationVar(&cmd.sampleInterval, "sample-interval", time.Second, "interval runc events collects the stats at")
+	fs.StringVar(&cmd.runc, "runc", "runc", "path to the runc binary")
+	fs.BoolVar(&cmd.stdin, "stdin", false, "read the events from stdin instead of running runc events")
+}
+
+type checkCommand struct {
+	fs             *flag.FlagSet
+	warn           thresholdFlag
+	crit           thresholdFlag
+	window         time.Duration
+	sampleInterval time.Duration
+	runc           string
+	stdin          bool
+}
+
+func (cmd *checkCommand) Run(ctx context.Context, args []string) error {
+	code, line := cmd.check(ctx, args)
+	fmt.Fprintf(os.Stdout, "MAGNETO %s - %s\n", checkStatuses[code], line)
+	os.Exit(code)
+	return nil
+}
+
+// check runs the check and returns its exit code and status line.
+func (cmd *checkCommand) check(ctx context.Context, args []string) (int, string) {
+	if len(args) < 1 {
+		return checkUnknown, "pass the container to check"
+	}
+	id := args[0]
+
+	// Parse the flags passed after the container. Invalid flags are returned
+	// as an error instead of exiting, so the check exits with UNKNOWN.
+	if len(args) > 1 {
+		cmd.fs.Init(cmd.fs.Name(), flag.ContinueOnError)
+		if err := cmd.fs.Parse(args[1:]); err != nil {
+			return checkUnknown, err.Error()
+		}
+		if cmd.fs.NArg() > 0 {
+			return checkUnknown, fmt.Sprintf("unexpected arguments: %s", strings.Join(cmd.fs.Args(), " "))
+		}
+	}
+	if cmd.window <= 0 || cmd.sampleInterval <= 0 {
+		return checkUnknown, "the window and sample interval must be greater than 0"
+	}
+
+	s := newStats()
+	if err := cmd.collect(ctx, s, id); err != nil {
+		return checkUnknown, fmt.Sprintf("%s: %v", id, err)
+	}
+
+	// The cpu percentage is calculated between two samples.
+	c := s.snapshot(id)
+	if c == nil || c.history.samples < 2 {
+		samples := 0
+		if c != nil {
+			samples = c.history.samples
+		}
+		return checkUnknown, fmt.Sprintf("%s: got %d samples in %s, need at least 2", id, samples, cmd.window)
+	}
+
+	return evaluateCheck(c, cmd.warn, cmd.crit)
+}
+
+// collect feeds the events for the container through the statistics for the
+// length of the window.
+func (cmd *checkCommand) collect(ctx context.Context, s *stats, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, cmd.window)
+	defer cancel()
+
+	var (
+		r      io.Reader = os.Stdin
+		stderr bytes.Buffer
+		runc   *exec.Cmd
+	)
+	if !cmd.stdin {
+		runc = exec.CommandContext(ctx, cmd.runc, "--root", root, "events", "--interval", cmd.sampleInterval.String(), id)
+		runc.Stderr = &stderr
+		out, err := runc.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := runc.Start(); err != nil {
+			return err
+		}
+		r = out
+	}
+
+	var (
+		er   = newEventReader(r)
+		done = make(chan error, 1)
+	)
+	go func() {
+		for {
+			e, err := er.Read()
+			if err != nil {
+				done <- err
+				return
+			}
+			if e.ID != id || e.Type != "stats" {
+				continue
+			}
+			systemUsage, err := s.systemCPU.Read()
+			if err != nil {
+				done <- fmt.Errorf("collecting system cpu usage failed: %v", err)
+				return
+			}
+			s.process(captureRecord{Time: time.Now(), Source: "check", SystemCPUUsage: systemUsage, Event: e})
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-done:
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if runc != nil {
+		// runc events is killed at the end of the window, so only report
+		// its error if it exited on its own.
+		exited := ctx.Err() == nil
+		cancel()
+		if werr := runc.Wait(); werr != nil && exited && err == nil {
+			err = fmt.Errorf("runc events failed: %v: %s", werr, bytes.TrimSpace(stderr.Bytes()))
+		}
+	}
+	return err
+}
+
+// evaluateCheck compares the statistics of the container with the thresholds
+// and returns the exit code and status line.
+func evaluateCheck(c *containerStats, warn, crit thresholdFlag) (int, string) {
+	var (
+		code     = checkOK
+		problems []string
+		reported = map[string]bool{}
+	)
+	// Only report the most severe threshold crossed for every metric.
+	for _, level := range []struct {
+		name       string
+		code       int
+		thresholds thresholdFlag
+	}{
+		{"critical", checkCritical, crit},
+		{"warning", checkWarning, warn},
+	} {
+		for _, t := range level.thresholds {
+			v := ruleMetrics[t.metric].value(c)
+			if reported[t.metric] || !t.alert(v) {
+				continue
+			}
+			reported[t.metric] = true
+			if level.code > code {
+				code = level.code
+			}
+			problems = append(problems, fmt.Sprintf("%s %s (%s %s)", t.metric, formatCheckValue(t.metric, v), level.name, t.raw))
+		}
+	}
+
+	text := strings.Join(problems, ", ")
+	if code == checkOK {
+		text = fmt.Sprintf("cpu %s, mem %s / %s, pids %d",
+			formatCheckValue("cpu", c.CPUPercentage),
+			units.BytesSize(c.Memory), units.BytesSize(c.MemoryLimit),
+			c.PidsCurrent)
+	}
+
+	return code, fmt.Sprintf("%s: %s | %s", c.ID, text, checkPerfdataLine(c, warn, crit))
+}
+
+// checkPerfdataLine returns the performance data for the default metrics and
+// every metric with a threshold.
+func checkPerfdataLine(c *containerStats, warn, crit thresholdFlag) string {
+	var (
+		metrics = append([]string(nil), checkPerfdata...)
+		extra   []string
+		seen    = map[string]bool{}
+	)
+	for _, m := range metrics {
+		seen[m] = true
+	}
+	for _, ts := range []thresholdFlag{warn, crit} {
+		for _, t := range ts {
+			if !seen[t.metric] {
+				seen[t.metric] = true
+				extra = append(extra, t.metric)
+			}
+		}
+	}
+	sort.Strings(extra)
+	metrics = append(metrics, extra...)
+
+	perfdata := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		var w, cr string
+		for _, t := range warn {
+			if t.metric == m {
+				w = t.perfdata()
+			}
+		}
+		for _, t := range crit {
+			if t.metric == m {
+				cr = t.perfdata()
+			}
+		}
+
+		var uom, max string
+		switch ruleMetrics[m].unit {
+		case "%":
+			uom = "%"
+		case "bytes":
+			uom = "B"
+		}
+		switch m {
+		case "mem":
+			max = strconv.FormatFloat(c.MemoryLimit, 'f', -1, 64)
+		case "pids":
+			if c.PidsLimit > 0 {
+				max = strconv.FormatUint(c.PidsLimit, 10)
+			}
+		}
+
+		perfdata = append(perfdata, fmt.Sprintf("%s=%s%s;%s;%s;0;%s",
+			m, formatPerfdataValue(ruleMetrics[m].value(c)), uom, w, cr, max))
+	}
+	return strings.Join(perfdata, " ")
+}
+
+// formatPerfdataValue formats a value with at most two decimals.
+func formatPerfdataValue(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func formatCheckValue(metric string, v float64) string {
+	switch ruleMetrics[metric].unit {
+	case "%":
+		return fmt.Sprintf("%.2f%%", v)
+	case "bytes":
+		return units.BytesSize(v)
+	}
+	return formatPerfdataValue(v)
+}
+
+// threshold is a range in the monitoring plugins format for a metric.
+type threshold struct {
+	metric string
+	raw    string
+	start  float64
+	end    float64
+	// noStart and noEnd are set for ranges that are open on that side.
+	noStart bool
+	noEnd   bool
+	// inside is set when the alert is raised inside the range instead of
+	// outside of it.
+	inside bool
+}
+
+// parseThreshold parses a threshold in the format metric=range.
+func parseThreshold(s string) (*threshold, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid threshold %q, must be like cpu=80", s)
+	}
+	t := &threshold{raw: s[i+1:]}
+	t.metric = percentMetric(s[:i], strings.Split(strings.TrimPrefix(t.raw, "@"), ":")...)
+	m, ok := ruleMetrics[t.metric]
+	if !ok {
+		return nil, fmt.Errorf("invalid threshold %q, unknown metric %s, must be one of %s", s, t.metric, strings.Join(ruleMetricNames(), ", "))
+	}
+
+	r := t.raw
+	if strings.HasPrefix(r, "@") {
+		t.inside = true
+		r = r[1:]
+	}
+
+	start, end := "0", r
+	if j := strings.Index(r, ":"); j >= 0 {
+		start, end = r[:j], r[j+1:]
+	}
+
+	var err error
+	switch start {
+	case "~":
+		t.noStart = true
+	case "":
+	default:
+		if t.start, err = parseRuleValue(start, m.unit); err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %v", s, err)
+		}
+	}
+	if end == "" {
+		t.noEnd = true
+	} else if t.end, err = parseRuleValue(end, m.unit); err != nil {
+		return nil, fmt.Errorf("invalid threshold %q: %v", s, err)
+	}
+	if !t.noStart && !t.noEnd && t.start > t.end {
+		return nil, fmt.Errorf("invalid threshold %q, the start of the range is above its end", s)
+	}
+
+	return t, nil
+}
+
+// perfdata returns the range with plain numbers, since the performance data
+// does not allow units in the thresholds.
+func (t *threshold) perfdata() string {
+	var r string
+	if t.inside {
+		r = "@"
+	}
+	if t.noStart {
+		r += "~:"
+	} else if t.start != 0 {
+		r += formatPerfdataValue(t.start) + ":"
+	}
+	if !t.noEnd {
+		r += formatPerfdataValue(t.end)
+	}
+	return r
+}
+
+// alert returns whether the value raises an alert for the threshold.
+func (t *threshold) alert(v float64) bool {
+	outside := (!t.noStart && v < t.start) || (!t.noEnd && v > t.end)
+	if t.inside {
+		return !outside
+	}
+	return outside
+}
+
+// thresholdFlag collects the thresholds passed with a repeatable flag.
+type thresholdFlag []*threshold
+
+func (f *thresholdFlag) String() string {
+	thresholds := make([]string, 0, len(*f))
+	for _, t := range *f {
+		thresholds = append(thresholds, t.metric+"="+t.raw)
+	}
+	return strings.Join(thresholds, ", ")
+}
+
+func (f *thresholdFlag) Set(s string) error {
+	t, err := parseThreshold(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, t)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParseThreshold(t *testing.T) {
+	testCases := []struct {
+		threshold string
+		metric    string
+		perfdata  string
+		wantErr   bool
+		// alerts are the values that raise an alert, ok are the ones that do
+		// not.
+		alerts []float64
+		ok     []float64
+	}{
+		{threshold: "cpu=80", metric: "cpu", perfdata: "80", alerts: []float64{-1, 80.5}, ok: []float64{0, 80}},
+		{threshold: "cpu=10:", metric: "cpu", perfdata: "10:", alerts: []float64{9}, ok: []float64{10, 1e9}},
+		{threshold: "cpu=~:80", metric: "cpu", perfdata: "~:80", alerts: []float64{81}, ok: []float64{-1e9, 80}},
+		{threshold: "cpu=10:80", metric: "cpu", perfdata: "10:80", alerts: []float64{9, 81}, ok: []float64{10, 80}},
+		{threshold: "cpu=@10:80", metric: "cpu", perfdata: "@10:80", alerts: []float64{10, 50, 80}, ok: []float64{9, 81}},
+		{threshold: "throttled_ratio=20%", metric: "throttled_ratio", perfdata: "0.2", alerts: []float64{0.3}, ok: []float64{0.2}},
+		{threshold: "mem=1GiB", metric: "mem", perfdata: "1073741824", alerts: []float64{1073741825}, ok: []float64{1073741824}},
+		{threshold: "mem=0:512MiB", metric: "mem", perfdata: "536870912", alerts: []float64{536870913}, ok: []float64{0}},
+		{threshold: "mem_pct=70", metric: "mem_pct", perfdata: "70", alerts: []float64{71}, ok: []float64{70}},
+		{threshold: "mem=70", metric: "mem_pct", perfdata: "70", alerts: []float64{71}, ok: []float64{70}},
+		{threshold: "mem=@50%:80%", metric: "mem_pct", perfdata: "@50:80", alerts: []float64{60}, ok: []float64{40}},
+		{threshold: "net_rx=70%", wantErr: true},
+		{threshold: "cpu=80:10", wantErr: true},
+		{threshold: "cpu", wantErr: true},
+		{threshold: "disk=80", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.threshold, func(t *testing.T) {
+			th, err := parseThreshold(tc.threshold)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", th)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if th.metric != tc.metric {
+				t.Fatalf("expected metric %s, got %s", tc.metric, th.metric)
+			}
+			if got := th.perfdata(); got != tc.perfdata {
+				t.Fatalf("expected perfdata %q, got %q", tc.perfdata, got)
+			}
+			for _, v := range tc.alerts {
+				if !th.alert(v) {
+					t.Errorf("expected %v to raise an alert", v)
+				}
+			}
+			for _, v := range tc.ok {
+				if th.alert(v) {
+					t.Errorf("expected %v not to raise an alert", v)
+				}
+			}
+		})
+	}
+}
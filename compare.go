@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// compareMinSamples is the minimum number of samples of a metric in both
+// captures for the test to be run.
+const compareMinSamples = 5
+
+// compareMetrics are the summary metrics compared between captures.
+var compareMetrics = []string{"cpu_percentage", "memory", "network_rx", "network_tx", "block_read", "block_write"}
+
+const compareShortHelp = `Compare the stats of a baseline and a candidate capture.`
+
+var compareHelp = compareShortHelp + `
+
+The containers of the captures are paired by id, or with --pair when their
+ids differ. If both captures have a single container they are paired too.
+The distributions of the cpu percentage, memory and I/O rates are compared
+with a Mann-Whitney U test, and a metric regressed when the candidate is
+significantly higher with at least a small effect size, measured with Cliff's
+delta. Exits with an error if a metric regressed.`
+
+func (cmd *compareCommand) Name() string      { return "compare" }
+func (cmd *compareCommand) Args() string      { return "[OPTIONS] BASELINE CANDIDATE" }
+func (cmd *compareCommand) ShortHelp() string { return compareShortHelp }
+func (cmd *compareCommand) LongHelp() string  { return compareHelp }
+func (cmd *compareCommand) Hidden() bool      { return false }
+
+func (cmd *compareCommand) Register(fs *flag.FlagSet) {
+	fs.Float64Var(&cmd.alpha, "alpha", 0.05, "significance level of the test")
+	fs.Float64Var(&cmd.minEffect, "min-effect", 0.147, "minimum absolute Cliff's delta for a significant change to count")
+	fs.Var(&cmd.pairs, "pair", "pair containers with different ids as baseline=candidate, can be passed multiple times")
+}
+
+type compareCommand struct {
+	alpha     float64
+	minEffect float64
+	pairs     stringsFlag
+}
+
+// comparison is the result of comparing a metric of a container between the
+// captures.
+type comparison struct {
+	Baseline         string  `json:"baseline"`
+	Candidate        string  `json:"candidate"`
+	Metric           string  `json:"metric"`
+	BaselineSamples  int     `json:"baseline_samples"`
+	CandidateSamples int     `json:"candidate_samples"`
+	BaselineMedian   float64 `json:"baseline_median"`
+	CandidateMedian  float64 `json:"candidate_median"`
+	U                float64 `json:"u"`
+	P                float64 `json:"p"`
+	Effect           float64 `json:"effect"`
+	// Result is regressed, improved, unchanged or too few samples.
+	Result string `json:"result"`
+}
+
+func (cmd *compareCommand) Run(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("pass the baseline and candidate capture files")
+	}
+	if cmd.alpha <= 0 || cmd.alpha >= 1 {
+		return fmt.Errorf("the significance level must be between 0 and 1")
+	}
+
+	baseline, err := loadCaptureStats(args[0])
+	if err != nil {
+		return err
+	}
+	candidate, err := loadCaptureStats(args[1])
+	if err != nil {
+		return err
+	}
+
+	pairs, err := pairContainers(baseline, candidate, cmd.pairs)
+	if err != nil {
+		return err
+	}
+
+	var (
+		results   []comparison
+		regressed int
+	)
+	for _, p := range pairs {
+		b, c := baseline.containers[p[0]], candidate.containers[p[1]]
+		for _, m := range compareMetrics {
+			r := compareSamples(b.history.values(m), c.history.values(m), cmd.alpha, cmd.minEffect)
+			r.Baseline, r.Candidate, r.Metric = p[0], p[1], m
+			if r.Result == "regressed" {
+				regressed++
+			}
+			results = append(results, r)
+		}
+	}
+
+	if format == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+			return err
+		}
+	} else if err := writeComparisons(results); err != nil {
+		return err
+	}
+
+	if regressed > 0 {
+		return fmt.Errorf("%d metrics regressed", regressed)
+	}
+	return nil
+}
+
+// loadCaptureStats processes the records of a capture file.
+func loadCaptureStats(path string) (*stats, error) {
+	_, records, err := readCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	s := newCaptureStats()
+	for _, r := range records {
+		s.process(r)
+	}
+	if len(s.containers) == 0 {
+		return nil, fmt.Errorf("%s has no stats events", path)
+	}
+	return s, nil
+}
+
+// pairContainers pairs the containers of the captures by id and with the
+// explicit pairs. If no container is paired and both captures have a single
+// container they are paired together.
+func pairContainers(baseline, candidate *stats, explicit []string) ([][2]string, error) {
+	var pairs [][2]string
+	paired := map[string]bool{}
+	for _, p := range explicit {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid pair %q, must be baseline=candidate", p)
+		}
+		b, c := p[:i], p[i+1:]
+		if _, ok := baseline.containers[b]; !ok {
+			return nil, fmt.Errorf("container %s is not in the baseline", b)
+		}
+		if _, ok := candidate.containers[c]; !ok {
+			return nil, fmt.Errorf("container %s is not in the candidate", c)
+		}
+		pairs = append(pairs, [2]string{b, c})
+		paired[b] = true
+	}
+
+	for _, c := range baseline.sorted() {
+		if _, ok := candidate.containers[c.ID]; ok && !paired[c.ID] {
+			pairs = append(pairs, [2]string{c.ID, c.ID})
+			paired[c.ID] = true
+		}
+	}
+
+	// Containers are recreated with new ids between runs, pair them by
+	// name as well.
+	names := map[string]string{}
+	for _, c := range candidate.sorted() {
+		if c.Name != "" {
+			names[c.Name] = c.ID
+		}
+	}
+	for _, c := range baseline.sorted() {
+		if id, ok := names[c.Name]; ok && c.Name != "" && !paired[c.ID] {
+			pairs = append(pairs, [2]string{c.ID, id})
+			paired[c.ID] = true
+		}
+	}
+
+	if len(pairs) == 0 && len(baseline.containers) == 1 && len(candidate.containers) == 1 {
+		pairs = append(pairs, [2]string{baseline.sorted()[0].ID, candidate.sorted()[0].ID})
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no containers in common between the captures, pair them with --pair")
+	}
+	return pairs, nil
+}
+
+// compareSamples compares the samples of the baseline and the candidate with
+// a two sided Mann-Whitney U test. Higher values are worse for all the
+// compared metrics, so a significant increase is a regression.
+func compareSamples(baseline, candidate []float64, alpha, minEffect float64) comparison {
+	r := comparison{
+		BaselineSamples:  len(baseline),
+		CandidateSamples: len(candidate),
+		Result:           "too few samples",
+	}
+	if len(baseline) < compareMinSamples || len(candidate) < compareMinSamples {
+		return r
+	}
+	r.BaselineMedian = median(baseline)
+	r.CandidateMedian = median(candidate)
+
+	r.U, r.P = mannWhitneyU(baseline, candidate)
+	r.Effect = cliffsDelta(r.U, len(baseline), len(candidate))
+
+	r.Result = "unchanged"
+	if r.P < alpha && math.Abs(r.Effect) >= minEffect {
+		r.Result = "improved"
+		if r.Effect > 0 {
+			r.Result = "regressed"
+		}
+	}
+	return r
+}
+
+// mannWhitneyU returns the U statistic of the candidate, the number of pairs
+// in which the candidate sample is higher counting ties as half, and the two
+// sided p-value from the normal approximation with the tie correction.
+func mannWhitneyU(a, b []float64) (float64, float64) {
+	type sample struct {
+		v         float64
+		candidate bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v: v})
+	}
+	for _, v := range b {
+		all = append(all, sample{v: v, candidate: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Rank the samples, giving tied samples the average of their ranks.
+	var rankSum, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2.0
+		for k := i; k < j; k++ {
+			if all[k].candidate {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	var (
+		n1 = float64(len(a))
+		n2 = float64(len(b))
+		n  = n1 + n2
+		u  = rankSum - n2*(n2+1)/2.0
+	)
+
+	mean := n1 * n2 / 2.0
+	variance := n1 * n2 / 12.0 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		// All the samples are equal.
+		return u, 1
+	}
+
+	// Continuity correction towards the mean.
+	diff := math.Abs(u-mean) - 0.5
+	if diff < 0 {
+		diff = 0
+	}
+	z := diff / math.Sqrt(variance)
+	return u, math.Erfc(z / math.Sqrt2)
+}
+
+// cliffsDelta returns the effect size from the U statistic of the candidate,
+// from -1 when all the candidate samples are lower to 1 when they are all
+// higher.
+func cliffsDelta(u float64, n1, n2 int) float64 {
+	return 2.0*u/float64(n1*n2) - 1.0
+}
+
+// effectSize describes the magnitude of Cliff's delta.
+func effectSize(d float64) string {
+	switch d = math.Abs(d); {
+	case d < 0.147:
+		return "negligible"
+	case d < 0.33:
+		return "small"
+	case d < 0.474:
+		return "medium"
+	}
+	return "large"
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return percentile(sorted, 50)
+}
+
+// writeComparisons writes the comparisons as a table.
+func writeComparisons(results []comparison) error {
+	headers := map[string]summaryMetric{}
+	for _, m := range summaryMetrics {
+		headers[m.name] = m
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CONTAINER\tMETRIC\tBASELINE P50\tCANDIDATE P50\tCHANGE\tP-VALUE\tEFFECT\tRESULT\n")
+	for _, r := range results {
+		container := r.Baseline
+		if r.Candidate != r.Baseline {
+			container = r.Baseline + " / " + r.Candidate
+		}
+		m := headers[r.Metric]
+
+		if r.Result == "too few samples" {
+			fmt.Fprintf(w, "%s\t%s\t\t\t\t\t\t%s (%d / %d)\n",
+				container, m.header, r.Result, r.BaselineSamples, r.CandidateSamples)
+			continue
+		}
+
+		change := "-"
+		if r.BaselineMedian != 0 {
+			change = fmt.Sprintf("%+.1f%%", (r.CandidateMedian-r.BaselineMedian)/r.BaselineMedian*100.0)
+		}
+		result := r.Result
+		if r.Result == "regressed" {
+			result = colorRed + result + colorReset
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.4f\t%+.2f %s\t%s\n",
+			container, m.header,
+			m.format(r.BaselineMedian), m.format(r.CandidateMedian),
+			change, r.P, r.Effect, effectSize(r.Effect), result)
+	}
+	return w.Flush()
+}
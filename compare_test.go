@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	testCases := []struct {
+		name      string
+		baseline  []float64
+		candidate []float64
+		u         float64
+		p         float64
+		effect    float64
+	}{
+		{
+			name:      "candidate higher",
+			baseline:  []float64{1, 2, 3},
+			candidate: []float64{4, 5, 6},
+			u:         9,
+			p:         0.0808555983700523,
+			effect:    1,
+		},
+		{
+			name:      "candidate lower",
+			baseline:  []float64{4, 5, 6},
+			candidate: []float64{1, 2, 3},
+			u:         0,
+			p:         0.0808555983700523,
+			effect:    -1,
+		},
+		{
+			name:      "ties",
+			baseline:  []float64{1, 2, 2, 3},
+			candidate: []float64{2, 3, 3, 4},
+			u:         13,
+			p:         0.17203370892182296,
+			effect:    0.625,
+		},
+		{
+			name:      "all equal",
+			baseline:  []float64{5, 5, 5},
+			candidate: []float64{5, 5},
+			u:         3,
+			p:         1,
+			effect:    0,
+		},
+		{
+			name:      "interleaved",
+			baseline:  []float64{1, 3, 5},
+			candidate: []float64{2, 4, 6},
+			u:         6,
+			p:         0.6625205835400574,
+			effect:    1.0 / 3.0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, p := mannWhitneyU(tc.baseline, tc.candidate)
+			if u != tc.u {
+				t.Fatalf("expected U %v, got %v", tc.u, u)
+			}
+			if math.Abs(p-tc.p) > 1e-9 {
+				t.Fatalf("expected p-value %v, got %v", tc.p, p)
+			}
+			if effect := cliffsDelta(u, len(tc.baseline), len(tc.candidate)); math.Abs(effect-tc.effect) > 1e-9 {
+				t.Fatalf("expected effect %v, got %v", tc.effect, effect)
+			}
+		})
+	}
+}
+
+func TestCompareSamples(t *testing.T) {
+	series := func(start, step float64, n int) []float64 {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = start + step*float64(i)
+		}
+		return values
+	}
+
+	testCases := []struct {
+		name      string
+		baseline  []float64
+		candidate []float64
+		want      string
+	}{
+		{"too few samples", []float64{1}, []float64{2}, "too few samples"},
+		{"regressed", series(10, 0.1, 30), series(20, 0.1, 30), "regressed"},
+		{"improved", series(20, 0.1, 30), series(10, 0.1, 30), "improved"},
+		{"unchanged", series(10, 0.1, 30), series(10.05, 0.1, 30), "unchanged"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := compareSamples(tc.baseline, tc.candidate, 0.05, 0.147).Result; got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEffectSize(t *testing.T) {
+	testCases := []struct {
+		delta float64
+		want  string
+	}{
+		{0, "negligible"},
+		{-0.1, "negligible"},
+		{0.2, "small"},
+		{-0.4, "medium"},
+		{0.9, "large"},
+		{-1, "large"},
+	}
+
+	for _, tc := range testCases {
+		if got := effectSize(tc.delta); got != tc.want {
+			t.Errorf("expected %s for %v, got %s", tc.want, tc.delta, got)
+		}
+	}
+}
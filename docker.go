@@ -0,0 +1,166 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// dockerStats is a sample of the Docker Engine stats API, as returned by
+// /containers/{id}/stats.
+type dockerStats struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	Read time.Time `json:"read"`
+
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage        uint64   `json:"total_usage"`
+			PercpuUsage       []uint64 `json:"percpu_usage"`
+			UsageInKernelmode uint64   `json:"usage_in_kernelmode"`
+			UsageInUsermode   uint64   `json:"usage_in_usermode"`
+		} `json:"cpu_usage"`
+		SystemCPUUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs     uint32 `json:"online_cpus"`
+		ThrottlingData struct {
+			Periods          uint64 `json:"periods"`
+			ThrottledPeriods uint64 `json:"throttled_periods"`
+			ThrottledTime    uint64 `json:"throttled_time"`
+		} `json:"throttling_data"`
+	} `json:"cpu_stats"`
+
+	MemoryStats struct {
+		Usage    uint64            `json:"usage"`
+		MaxUsage uint64            `json:"max_usage"`
+		Stats    map[string]uint64 `json:"stats"`
+		Failcnt  uint64            `json:"failcnt"`
+		Limit    uint64            `json:"limit"`
+	} `json:"memory_stats"`
+
+	PidsStats struct {
+		Current uint64 `json:"current"`
+		Limit   uint64 `json:"limit"`
+	} `json:"pids_stats"`
+
+	BlkioStats struct {
+		IoServiceBytesRecursive []types.BlkioEntry `json:"io_service_bytes_recursive"`
+		IoServicedRecursive     []types.BlkioEntry `json:"io_serviced_recursive"`
+		IoQueuedRecursive       []types.BlkioEntry `json:"io_queue_recursive"`
+		IoServiceTimeRecursive  []types.BlkioEntry `json:"io_service_time_recursive"`
+		IoWaitTimeRecursive     []types.BlkioEntry `json:"io_wait_time_recursive"`
+		IoMergedRecursive       []types.BlkioEntry `json:"io_merged_recursive"`
+		IoTimeRecursive         []types.BlkioEntry `json:"io_time_recursive"`
+		SectorsRecursive        []types.BlkioEntry `json:"sectors_recursive"`
+	} `json:"blkio_stats"`
+
+	Networks map[string]struct {
+		RxBytes   uint64 `json:"rx_bytes"`
+		RxPackets uint64 `json:"rx_packets"`
+		RxErrors  uint64 `json:"rx_errors"`
+		RxDropped uint64 `json:"rx_dropped"`
+		TxBytes   uint64 `json:"tx_bytes"`
+		TxPackets uint64 `json:"tx_packets"`
+		TxErrors  uint64 `json:"tx_errors"`
+		TxDropped uint64 `json:"tx_dropped"`
+	} `json:"networks"`
+}
+
+// event converts the sample to a runc stats event.
+func (d *dockerStats) event() event {
+	var v types.Stats
+
+	cpu := d.CPUStats
+	v.CPU.Usage = types.CPUUsage{
+		Total:  cpu.CPUUsage.TotalUsage,
+		Kernel: cpu.CPUUsage.UsageInKernelmode,
+		User:   cpu.CPUUsage.UsageInUsermode,
+	}
+	// The cpu usage is relative to the system cpu usage of the engine, which
+	// is summed over its online cpus, so the percentage is scaled by them
+	// like docker stats does. The per cpu usages are not reported on cgroup
+	// v2, and the older APIs do not report the online cpus.
+	cpus := int(cpu.OnlineCPUs)
+	if cpus == 0 {
+		cpus = len(cpu.CPUUsage.PercpuUsage)
+	}
+	var systemUsage uint64
+	switch {
+	case cpu.SystemCPUUsage != 0 && cpus > 0:
+		systemUsage = cpu.SystemCPUUsage
+		v.CPU.Usage.Percpu = cpu.CPUUsage.PercpuUsage
+		if len(v.CPU.Usage.Percpu) != cpus {
+			v.CPU.Usage.Percpu = make([]uint64, cpus)
+		}
+	case !d.Read.IsZero():
+		// Without the cpus the usage is over the time between the samples,
+		// counted for a single cpu like the kubelet samples.
+		systemUsage = uint64(d.Read.UnixNano())
+		v.CPU.Usage.Percpu = make([]uint64, 1)
+	default:
+		// The system cpu usage of this host is read instead, scaled by its
+		// cpus.
+		v.CPU.Usage.Percpu = make([]uint64, runtime.NumCPU())
+	}
+	v.CPU.Throttling = types.Throttling{
+		Periods:          cpu.ThrottlingData.Periods,
+		ThrottledPeriods: cpu.ThrottlingData.ThrottledPeriods,
+		ThrottledTime:    cpu.ThrottlingData.ThrottledTime,
+	}
+
+	mem := d.MemoryStats
+	v.Memory.Usage = types.MemoryEntry{
+		Usage:   mem.Usage,
+		Max:     mem.MaxUsage,
+		Limit:   mem.Limit,
+		Failcnt: mem.Failcnt,
+	}
+	v.Memory.Raw = mem.Stats
+	// cgroup v2 has no page cache counter, docker uses the inactive files
+	// instead.
+	cache, ok := mem.Stats["cache"]
+	if !ok {
+		cache = mem.Stats["inactive_file"]
+	}
+	if cache <= mem.Usage {
+		v.Memory.Cache = cache
+	}
+
+	v.Pids = types.Pids{Current: d.PidsStats.Current, Limit: d.PidsStats.Limit}
+
+	blkio := d.BlkioStats
+	v.Blkio = types.Blkio{
+		IoServiceBytesRecursive: blkio.IoServiceBytesRecursive,
+		IoServicedRecursive:     blkio.IoServicedRecursive,
+		IoQueuedRecursive:       blkio.IoQueuedRecursive,
+		IoServiceTimeRecursive:  blkio.IoServiceTimeRecursive,
+		IoWaitTimeRecursive:     blkio.IoWaitTimeRecursive,
+		IoMergedRecursive:       blkio.IoMergedRecursive,
+		IoTimeRecursive:         blkio.IoTimeRecursive,
+		SectorsRecursive:        blkio.SectorsRecursive,
+	}
+
+	names := make([]string, 0, len(d.Networks))
+	for name := range d.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := d.Networks[name]
+		v.NetworkInterfaces = append(v.NetworkInterfaces, &types.NetworkInterface{
+			Name:      name,
+			RxBytes:   n.RxBytes,
+			RxPackets: n.RxPackets,
+			RxErrors:  n.RxErrors,
+			RxDropped: n.RxDropped,
+			TxBytes:   n.TxBytes,
+			TxPackets: n.TxPackets,
+			TxErrors:  n.TxErrors,
+			TxDropped: n.TxDropped,
+		})
+	}
+
+	return event{Type: "stats", ID: d.ID, Name: strings.TrimPrefix(d.Name, "/"), Data: v, systemUsage: systemUsage}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"testing"
+
+	mstats "github.com/genuinetools/magneto/stats"
+)
+
+// dockerSample is a sample of the stats API of Docker Engine 19.03 on cgroup
+// v1.
+const dockerSample = `{"read":"2020-01-01T00:00:01.000000000Z","preread":"2020-01-01T00:00:00.000000000Z","pids_stats":{"current":3,"limit":100},"blkio_stats":{"io_service_bytes_recursive":[{"major":8,"minor":0,"op":"Read","value":2002944},{"major":8,"minor":0,"op":"Write","value":4096},{"major":8,"minor":0,"op":"Sync","value":2002944},{"major":8,"minor":0,"op":"Async","value":4096},{"major":8,"minor":0,"op":"Total","value":2007040}],"io_serviced_recursive":[{"major":8,"minor":0,"op":"Read","value":42},{"major":8,"minor":0,"op":"Write","value":1}],"io_queue_recursive":[],"io_service_time_recursive":[],"io_wait_time_recursive":[],"io_merged_recursive":[],"io_time_recursive":[],"sectors_recursive":[]},"num_procs":0,"storage_stats":{},"cpu_stats":{"cpu_usage":{"total_usage":300000000,"percpu_usage":[100000000,100000000,50000000,50000000],"usage_in_kernelmode":100000000,"usage_in_usermode":200000000},"system_cpu_usage":1004000000000,"online_cpus":4,"throttling_data":{"periods":10,"throttled_periods":2,"throttled_time":5000000}},"precpu_stats":{"cpu_usage":{"total_usage":100000000,"percpu_usage":[50000000,50000000,0,0],"usage_in_kernelmode":30000000,"usage_in_usermode":70000000},"system_cpu_usage":1000000000000,"online_cpus":4,"throttling_data":{"periods":0,"throttled_periods":0,"throttled_time":0}},"memory_stats":{"usage":6537216,"max_usage":8216576,"stats":{"cache":1245184,"rss":4718592,"total_cache":1245184,"total_rss":4718592},"limit":67108864},"name":"/web","id":"b3e8a2f7d1c4","networks":{"eth1":{"rx_bytes":100,"rx_packets":1,"rx_errors":0,"rx_dropped":0,"tx_bytes":200,"tx_packets":2,"tx_errors":0,"tx_dropped":0},"eth0":{"rx_bytes":5338,"rx_packets":36,"rx_errors":0,"rx_dropped":0,"tx_bytes":648,"tx_packets":8,"tx_errors":0,"tx_dropped":0}}}`
+
+func TestDockerStatsEvent(t *testing.T) {
+	var d dockerStats
+	if err := json.Unmarshal([]byte(dockerSample), &d); err != nil {
+		t.Fatal(err)
+	}
+	e := d.event()
+
+	if e.Type != "stats" || e.ID != "b3e8a2f7d1c4" || e.Name != "web" {
+		t.Fatalf("expected the stats of b3e8a2f7d1c4 named web, got %s %s %s", e.Type, e.ID, e.Name)
+	}
+	if e.systemUsage != 1004000000000 {
+		t.Fatalf("expected the system cpu usage of the engine, got %d", e.systemUsage)
+	}
+
+	v := e.Data
+	if v.CPU.Usage.Total != 300000000 || v.CPU.Usage.Kernel != 100000000 || v.CPU.Usage.User != 200000000 {
+		t.Fatalf("unexpected cpu usage %+v", v.CPU.Usage)
+	}
+	if len(v.CPU.Usage.Percpu) != 4 {
+		t.Fatalf("expected 4 cpus, got %d", len(v.CPU.Usage.Percpu))
+	}
+	if v.CPU.Throttling.ThrottledPeriods != 2 || v.CPU.Throttling.Periods != 10 {
+		t.Fatalf("unexpected throttling %+v", v.CPU.Throttling)
+	}
+	if v.Memory.Usage.Usage != 6537216 || v.Memory.Usage.Max != 8216576 || v.Memory.Usage.Limit != 67108864 || v.Memory.Cache != 1245184 {
+		t.Fatalf("unexpected memory %+v cache %d", v.Memory.Usage, v.Memory.Cache)
+	}
+	if v.Pids.Current != 3 || v.Pids.Limit != 100 {
+		t.Fatalf("unexpected pids %+v", v.Pids)
+	}
+	if read, write := mstats.BlockIO(v.Blkio); read != 2002944 || write != 4096 {
+		t.Fatalf("expected 2002944 bytes read and 4096 written, got %d and %d", read, write)
+	}
+
+	var names []string
+	for _, n := range v.NetworkInterfaces {
+		names = append(names, n.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"eth0", "eth1"}) {
+		t.Fatalf("expected the interfaces sorted by name, got %v", names)
+	}
+}
+
+func TestDockerStatsCPU(t *testing.T) {
+	testCases := []struct {
+		name     string
+		previous string
+		current  string
+		// cpus is the number of cpus the percentage is scaled by.
+		cpus     int
+		expected float64
+	}{
+		{
+			name:     "cgroup v1",
+			previous: `{"read":"2020-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":100000000,"percpu_usage":[50000000,50000000,0,0]},"system_cpu_usage":1000000000000,"online_cpus":4}}`,
+			current:  `{"read":"2020-01-01T00:00:01Z","cpu_stats":{"cpu_usage":{"total_usage":300000000,"percpu_usage":[100000000,100000000,50000000,50000000]},"system_cpu_usage":1004000000000,"online_cpus":4}}`,
+			cpus:     4,
+			expected: 20,
+		},
+		{
+			name:     "cgroup v2",
+			previous: `{"read":"2020-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":1000000000},"system_cpu_usage":500000000000,"online_cpus":2}}`,
+			current:  `{"read":"2020-01-01T00:00:01Z","cpu_stats":{"cpu_usage":{"total_usage":1500000000},"system_cpu_usage":502000000000,"online_cpus":2}}`,
+			cpus:     2,
+			expected: 50,
+		},
+		{
+			name:     "no online cpus",
+			previous: `{"read":"2020-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":100000000,"percpu_usage":[50000000,50000000]},"system_cpu_usage":1000000000000}}`,
+			current:  `{"read":"2020-01-01T00:00:01Z","cpu_stats":{"cpu_usage":{"total_usage":1100000000,"percpu_usage":[600000000,500000000]},"system_cpu_usage":1002000000000}}`,
+			cpus:     2,
+			expected: 100,
+		},
+		{
+			name:     "no cpus",
+			previous: `{"read":"2020-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":100000000},"system_cpu_usage":1000000000000}}`,
+			current:  `{"read":"2020-01-01T00:00:02Z","cpu_stats":{"cpu_usage":{"total_usage":600000000},"system_cpu_usage":1008000000000}}`,
+			cpus:     1,
+			expected: 25,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var previous, current dockerStats
+			if err := json.Unmarshal([]byte(tc.previous), &previous); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.current), &current); err != nil {
+				t.Fatal(err)
+			}
+			p, c := previous.event(), current.event()
+
+			if got := len(c.Data.CPU.Usage.Percpu); got != tc.cpus {
+				t.Fatalf("expected %d cpus, got %d", tc.cpus, got)
+			}
+			got := mstats.CPUPercent(p.Data.CPU.Usage.Total, p.systemUsage, c.systemUsage, c.Data)
+			if !approxEqual(got, tc.expected) {
+				t.Fatalf("expected %.2f%% cpu, got %.2f%%", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDockerStatsWithoutSystemUsage(t *testing.T) {
+	// Without the system cpu usage and the read time the system cpu usage of
+	// this host is read, scaled by its cpus.
+	var d dockerStats
+	if err := json.Unmarshal([]byte(`{"id":"abc","cpu_stats":{"cpu_usage":{"total_usage":100}}}`), &d); err != nil {
+		t.Fatal(err)
+	}
+	e := d.event()
+	if e.systemUsage != 0 {
+		t.Fatalf("expected no system cpu usage, got %d", e.systemUsage)
+	}
+	if got := len(e.Data.CPU.Usage.Percpu); got != runtime.NumCPU() {
+		t.Fatalf("expected %d cpus, got %d", runtime.NumCPU(), got)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultDockerSocket is the socket of the Docker Engine API.
+	defaultDockerSocket = "/var/run/docker.sock"
+)
+
+// dockerContainer is a container listed by /containers/json.
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// dockerEvent is an event of /events.
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// dockerResult is an event or the error the source failed with.
+type dockerResult struct {
+	e   event
+	err error
+}
+
+// dockerSource reads the stats of the running containers from the Docker
+// Engine API on a unix socket. The containers are listed when it starts and
+// tracked through the events of the engine, streaming the stats of every
+// container while it runs.
+type dockerSource struct {
+	name   string
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    chan dockerResult
+
+	// malformed is the number of stats samples that could not be decoded.
+	malformed uint64
+
+	mu sync.Mutex
+	// streams are the containers whose stats are being streamed.
+	streams map[string]bool
+	// paused are the containers whose samples are dropped until they are
+	// unpaused, the engine keeps sending them unchanged.
+	paused map[string]bool
+}
+
+// newDockerSource connects to the Docker Engine API on the socket.
+func newDockerSource(name, socket string) (*dockerSource, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &dockerSource{
+		name: name,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		ctx:     ctx,
+		cancel:  cancel,
+		out:     make(chan dockerResult),
+		streams: map[string]bool{},
+		paused:  map[string]bool{},
+	}
+
+	// Subscribe to the events before listing the containers so no
+	// container starting in between is missed.
+	events, err := s.get("/events?filters=" + url.QueryEscape(`{"type":["container"]}`))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var containers []dockerContainer
+	if err := s.getJSON("/containers/json", &containers); err != nil {
+		events.Close()
+		cancel()
+		return nil, err
+	}
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		s.stream(c.ID, name, c.Labels)
+	}
+
+	go s.watchEvents(events)
+	return s, nil
+}
+
+func (s *dockerSource) Name() string      { return s.name }
+func (s *dockerSource) Malformed() uint64 { return atomic.LoadUint64(&s.malformed) }
+
+func (s *dockerSource) Read() (event, error) {
+	select {
+	case r := <-s.out:
+		return r.e, r.err
+	case <-s.ctx.Done():
+		return event{}, io.EOF
+	}
+}
+
+// Close stops the requests to the engine.
+func (s *dockerSource) Close() error {
+	s.cancel()
+	return nil
+}
+
+// send passes the result to Read, unless the source was closed.
+func (s *dockerSource) send(r dockerResult) {
+	select {
+	case s.out <- r:
+	case <-s.ctx.Done():
+	}
+}
+
+// get requests a path of the API and returns the body of the response.
+func (s *dockerSource) get(path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s from the docker engine failed: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("requesting %s from the docker engine failed: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// getJSON requests a path of the API and decodes the response into v.
+func (s *dockerSource) getJSON(path string, v interface{}) error {
+	body, err := s.get(path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s from the docker engine failed: %v", path, err)
+	}
+	return nil
+}
+
+// watchEvents starts streaming the stats of the containers that start, passes
+// on their OOM kills and sends a stop event for the containers that stop or
+// are paused, so they are marked as stale right away. The source fails when
+// the events stream ends.
+func (s *dockerSource) watchEvents(body io.ReadCloser) {
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var e dockerEvent
+		if err := dec.Decode(&e); err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			s.send(dockerResult{err: fmt.Errorf("reading the docker events failed: %v", err)})
+			return
+		}
+		if e.Type != "container" {
+			continue
+		}
+
+		switch e.Action {
+		case "start", "unpause":
+			s.setPaused(e.Actor.ID, false)
+			name, labels := splitDockerAttributes(e.Actor.Attributes)
+			s.stream(e.Actor.ID, name, labels)
+		case "oom":
+			s.send(dockerResult{e: event{Type: "oom", ID: e.Actor.ID}})
+		case "pause":
+			s.setPaused(e.Actor.ID, true)
+			s.send(dockerResult{e: event{Type: "stop", ID: e.Actor.ID}})
+		case "die", "stop":
+			s.send(dockerResult{e: event{Type: "stop", ID: e.Actor.ID}})
+		}
+	}
+}
+
+func (s *dockerSource) setPaused(id string, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if paused {
+		s.paused[id] = true
+	} else {
+		delete(s.paused, id)
+	}
+}
+
+func (s *dockerSource) isPaused(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[id]
+}
+
+// splitDockerAttributes returns the name and the labels of a container from
+// the attributes of its events, which also have the image and the name.
+func splitDockerAttributes(attrs map[string]string) (string, map[string]string) {
+	labels := map[string]string{}
+	for k, v := range attrs {
+		if k != "name" && k != "image" {
+			labels[k] = v
+		}
+	}
+	return attrs["name"], labels
+}
+
+// stream streams the stats of a container until it stops, unless they are
+// streamed already.
+func (s *dockerSource) stream(id, name string, labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[id] {
+		return
+	}
+	s.streams[id] = true
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.streams, id)
+			s.mu.Unlock()
+		}()
+
+		body, err := s.get("/containers/" + url.PathEscape(id) + "/stats?stream=true")
+		if err != nil {
+			logrus.Debugf("streaming the stats of container %s failed: %v", id, err)
+			return
+		}
+		defer body.Close()
+
+		dec := json.NewDecoder(body)
+		for {
+			var d dockerStats
+			if err := dec.Decode(&d); err != nil {
+				if err != io.EOF && s.ctx.Err() == nil {
+					atomic.AddUint64(&s.malformed, 1)
+					logrus.Debugf("decoding the stats of container %s failed: %v", id, err)
+				}
+				return
+			}
+			// The engine sends empty samples once the container stopped.
+			if d.CPUStats.CPUUsage.TotalUsage == 0 && d.MemoryStats.Usage == 0 {
+				continue
+			}
+			if s.isPaused(id) {
+				continue
+			}
+
+			e := d.event()
+			e.ID = id
+			e.Name = name
+			e.Labels = labels
+			s.send(dockerResult{e: e})
+		}
+	}()
+}
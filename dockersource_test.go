@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDockerEngine serves the parts of the Docker Engine API the docker source
+// uses on a unix socket. The events sent on events are streamed to /events,
+// and the stats of the containers are streamed every 10ms.
+func fakeDockerEngine(t *testing.T, events <-chan string) (string, func()) {
+	dir, err := ioutil.TempDir("", "magneto-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"Id":"abc","Names":["/web"],"Labels":{"app":"web"}}]`)
+	})
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		for i := uint64(1); ; i++ {
+			var d dockerStats
+			d.CPUStats.CPUUsage.TotalUsage = i * 1e6
+			d.MemoryStats.Usage = 1024
+			if err := json.NewEncoder(w).Encode(d); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case e := <-events:
+				fmt.Fprintln(w, e)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = l
+	srv.Start()
+	return socket, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDockerSourceLifecycle(t *testing.T) {
+	events := make(chan string)
+	socket, stop := fakeDockerEngine(t, events)
+	defer stop()
+
+	src, err := newDockerSource("docker://"+socket, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	read := make(chan event)
+	go func() {
+		for {
+			e, err := src.Read()
+			if err != nil {
+				close(read)
+				return
+			}
+			read <- e
+		}
+	}()
+	// next returns the next event of the given type, skipping the others.
+	next := func(typ string) event {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case e, ok := <-read:
+				if !ok {
+					t.Fatal("the source ended")
+				}
+				if e.Type == typ {
+					return e
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for a %s event", typ)
+			}
+		}
+	}
+	action := func(action string) {
+		events <- fmt.Sprintf(`{"Type":"container","Action":%q,"Actor":{"ID":"abc","Attributes":{"name":"web"}}}`, action)
+	}
+
+	if e := next("stats"); e.ID != "abc" || e.Name != "web" || e.Labels["app"] != "web" {
+		t.Fatalf("unexpected stats event %+v", e)
+	}
+
+	action("pause")
+	if e := next("stop"); e.ID != "abc" {
+		t.Fatalf("unexpected stop event %+v", e)
+	}
+	// The samples of a paused container are dropped, but for one that may
+	// have been on its way when the container was paused.
+	select {
+	case <-read:
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case e := <-read:
+		t.Fatalf("unexpected event of a paused container %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	action("unpause")
+	next("stats")
+
+	action("die")
+	if e := next("stop"); e.ID != "abc" {
+		t.Fatalf("unexpected stop event %+v", e)
+	}
+
+	action("oom")
+	if e := next("oom"); e.ID != "abc" {
+		t.Fatalf("unexpected oom event %+v", e)
+	}
+}
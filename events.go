@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inputFormat is the format of the events of a stream.
+type inputFormat int
+
+const (
+	// formatUnknown is the format until the first event was decoded.
+	formatUnknown inputFormat = iota
+	// formatRunc is the JSON of runc events.
+	formatRunc
+	// formatDocker is the JSON of the Docker Engine stats API.
+	formatDocker
+	// formatPodman is the JSON of podman stats --format json.
+	formatPodman
+)
+
+// eventReader reads the events of a stream line by line, so a malformed line
+// is skipped and the next line is read as usual instead of the decoder
+// failing for the rest of the stream. The format of the stream is detected
+// from the first event, the samples of docker and podman are converted to
+// runc stats events.
+type eventReader struct {
+	br *bufio.Reader
+	// malformed is the number of lines that were skipped since they could
+	// not be decoded.
+	malformed uint64
+	format    inputFormat
+	// dec decodes the podman arrays, which span several lines.
+	dec *json.Decoder
+	// pending are the events of the last podman array not returned yet.
+	pending []event
+}
+
+func newEventReader(r io.Reader) *eventReader {
+	return &eventReader{br: bufio.NewReader(r)}
+}
+
+// Read returns the next event in the stream. It returns io.EOF when the
+// stream ended.
+func (r *eventReader) Read() (event, error) {
+	if r.format == formatUnknown {
+		if err := r.detectArray(); err != nil {
+			return event{}, err
+		}
+	}
+	if r.format == formatPodman {
+		return r.readPodman()
+	}
+
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			e, jerr := r.decode(line)
+			if jerr != nil {
+				r.malformed++
+				logrus.Debugf("skipping malformed event: %v", jerr)
+			} else {
+				return e, nil
+			}
+		}
+		if err != nil {
+			return event{}, err
+		}
+	}
+}
+
+// detectArray sets the format to podman if the stream starts with an array,
+// which is how podman writes the samples.
+func (r *eventReader) detectArray() error {
+	for {
+		b, err := r.br.Peek(1)
+		if err != nil {
+			return err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.br.ReadByte()
+			continue
+		case '[':
+			r.format = formatPodman
+			r.dec = json.NewDecoder(r.br)
+		}
+		return nil
+	}
+}
+
+// decode decodes a line in the format of the stream, detecting it from the
+// line if it is not known yet.
+func (r *eventReader) decode(line []byte) (event, error) {
+	if r.format == formatUnknown {
+		var probe struct {
+			CPUStats json.RawMessage `json:"cpu_stats"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			return event{}, err
+		}
+		r.format = formatRunc
+		if probe.CPUStats != nil {
+			r.format = formatDocker
+			logrus.Debug("reading docker stats")
+		}
+	}
+
+	if r.format == formatDocker {
+		var s dockerStats
+		if err := json.Unmarshal(line, &s); err != nil {
+			return event{}, err
+		}
+		return s.event(), nil
+	}
+
+	var e event
+	err := json.Unmarshal(line, &e)
+	return e, err
+}
+
+// readPodman returns the next event of the podman arrays. The decoder cannot
+// skip a malformed array, so it fails the stream.
+func (r *eventReader) readPodman() (event, error) {
+	for len(r.pending) == 0 {
+		var samples []podmanStats
+		if err := r.dec.Decode(&samples); err != nil {
+			if err != io.EOF {
+				r.malformed++
+			}
+			return event{}, err
+		}
+		for _, s := range samples {
+			e, err := s.event()
+			if err != nil {
+				r.malformed++
+				logrus.Debugf("skipping malformed podman sample: %v", err)
+				continue
+			}
+			r.pending = append(r.pending, e)
+		}
+	}
+
+	e := r.pending[0]
+	r.pending = r.pending[1:]
+	return e, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventReaderFormat(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		format    inputFormat
+		ids       []string
+		malformed uint64
+	}{
+		{
+			name:   "runc",
+			input:  `{"type":"stats","id":"abc","data":{"cpu":{"usage":{"total":1}}}}` + "\n" + `{"type":"oom","id":"abc"}` + "\n",
+			format: formatRunc,
+			ids:    []string{"abc", "abc"},
+		},
+		{
+			name:      "runc with malformed lines",
+			input:     "not json\n\n" + `{"type":"stats","id":"abc"}` + "\n{\"type\":\n" + `{"type":"stats","id":"def"}`,
+			format:    formatRunc,
+			ids:       []string{"abc", "def"},
+			malformed: 2,
+		},
+		{
+			name:   "docker",
+			input:  dockerSample + "\n" + strings.Replace(dockerSample, "b3e8a2f7d1c4", "c4f9b3e8a2d5", 1) + "\n",
+			format: formatDocker,
+			ids:    []string{"b3e8a2f7d1c4", "c4f9b3e8a2d5"},
+		},
+		{
+			name: "podman",
+			input: `
+[
+ {
+  "id": "e4b1c2d3a5f6",
+  "name": "web",
+  "cpu_time": "1.520314s",
+  "cpu_percent": "0.12%",
+  "avg_cpu": "0.10%",
+  "mem_usage": "2.404MB / 16GB",
+  "mem_percent": "0.02%",
+  "net_io": "5.338kB / 648B",
+  "block_io": "2.003MB / 4.096kB",
+  "pids": "3"
+ },
+ {
+  "id": "f5c2d3e4b6a7",
+  "name": "db",
+  "cpu_time": "bad",
+  "mem_usage": "-- / --",
+  "net_io": "-- / --",
+  "block_io": "-- / --",
+  "pids": "--"
+ }
+]
+[
+ {
+  "id": "e4b1c2d3a5f6",
+  "name": "web",
+  "cpu_time": "1.620314s",
+  "mem_usage": "2.404MB / 16GB",
+  "net_io": "5.338kB / 648B",
+  "block_io": "2.003MB / 4.096kB",
+  "pids": "3"
+ }
+]
+`,
+			format:    formatPodman,
+			ids:       []string{"e4b1c2d3a5f6", "e4b1c2d3a5f6"},
+			malformed: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newEventReader(strings.NewReader(tc.input))
+
+			var ids []string
+			for {
+				e, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, e.ID)
+			}
+
+			if r.format != tc.format {
+				t.Fatalf("expected format %d, got %d", tc.format, r.format)
+			}
+			if !reflect.DeepEqual(ids, tc.ids) {
+				t.Fatalf("expected events of %v, got %v", tc.ids, ids)
+			}
+			if r.malformed != tc.malformed {
+				t.Fatalf("expected %d malformed, got %d", tc.malformed, r.malformed)
+			}
+		})
+	}
+}
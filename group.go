@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	mstats "github.com/genuinetools/magneto/stats"
+)
+
+// grouping is how the containers are grouped in the table.
+type grouping struct {
+	// key returns the name of the group of the container, empty if it is
+	// not in a group.
+	key func(c *containerStats) string
+	// sharedNetwork is whether the containers of a group share their
+	// network namespace, like the containers of a pod.
+	sharedNetwork bool
+	// collapsed is whether the groups only show their totals until they
+	// are expanded.
+	collapsed bool
+}
+
+// memoryUnlimited is above the memory limits of the containers. cgroup v1
+// reports the largest page aligned int64 as the limit of a cgroup without one.
+const memoryUnlimited = 1 << 62
+
+// podGrouping groups the containers by pod, with the groups expanded.
+var podGrouping = &grouping{key: podName, sharedNetwork: true}
+
+// containerGrouping is the grouping of the table, nil to not group the
+// containers.
+var containerGrouping = podGrouping
+
+// parseGrouping parses a grouping: "label:KEY" groups the containers by the
+// value of a label, "regex:PATTERN" by the first submatch of the pattern in
+// their id, or the whole match if it has none, and "none" does not group
+// them. The groups are collapsed.
+func parseGrouping(s string) (*grouping, error) {
+	switch {
+	case s == "none":
+		return nil, nil
+	case strings.HasPrefix(s, "label:"):
+		key := strings.TrimPrefix(s, "label:")
+		if key == "" {
+			return nil, fmt.Errorf("missing label in group by %q", s)
+		}
+		return &grouping{
+			key:           func(c *containerStats) string { return c.Labels[key] },
+			sharedNetwork: key == labelPodName || key == labelPodUID,
+			collapsed:     true,
+		}, nil
+	case strings.HasPrefix(s, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in group by %q: %v", s, err)
+		}
+		return &grouping{
+			key: func(c *containerStats) string {
+				m := re.FindStringSubmatch(c.ID)
+				if len(m) > 1 {
+					return m[1]
+				}
+				if len(m) == 1 {
+					return m[0]
+				}
+				return ""
+			},
+			collapsed: true,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown group by %q, must be label:KEY, regex:PATTERN or none", s)
+}
+
+// containerGroup is a group of containers shown as a row with the totals of
+// the containers and the containers nested beneath.
+type containerGroup struct {
+	// name is the name of the group, empty for a container that is not in
+	// a group.
+	name       string
+	containers []*containerStats
+	// sharedNetwork is whether the containers share their network
+	// namespace, like the containers of a pod, so the network counters of
+	// the group are the ones of a container instead of the sum.
+	sharedNetwork bool
+}
+
+// podName returns the namespace and the name of the pod of the container
+// from its labels, empty if it is not in a pod.
+func podName(c *containerStats) string {
+	name := c.Labels[labelPodName]
+	if name == "" {
+		return ""
+	}
+	return c.Labels[labelPodNamespace] + "/" + name
+}
+
+// groups returns the containers grouped with the grouping of the table,
+// sorted by the name of the groups and of the containers not in a group.
+func (s *stats) groups() []*containerGroup {
+	var (
+		groups []*containerGroup
+		byName = map[string]*containerGroup{}
+	)
+	for _, c := range s.sorted() {
+		name := ""
+		if containerGrouping != nil {
+			name = containerGrouping.key(c)
+		}
+		if name == "" {
+			groups = append(groups, &containerGroup{containers: []*containerStats{c}})
+			continue
+		}
+		g, ok := byName[name]
+		if !ok {
+			g = &containerGroup{name: name, sharedNetwork: containerGrouping.sharedNetwork}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.containers = append(g.containers, c)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].key() < groups[j].key()
+	})
+	return groups
+}
+
+// key is what the groups are sorted by.
+func (g *containerGroup) key() string {
+	if g.name == "" {
+		return g.containers[0].displayName()
+	}
+	return g.name
+}
+
+// total returns the statistics of the group: the sum of the usage and the
+// limits of its containers and the worst memory percentage. The group has no
+// limit if one of its containers has none, and is stale if all its
+// containers are.
+func (g *containerGroup) total() *containerStats {
+	t := &containerStats{ID: g.name, Source: g.containers[0].Source, Stale: true}
+	limitedMemory, limitedPids := true, true
+	for i, c := range g.containers {
+		t.CPUPercentage += c.CPUPercentage
+		t.Memory += c.Memory
+		t.MemoryLimit += c.MemoryLimit
+		if c.MemoryLimit == 0 || c.MemoryLimit >= memoryUnlimited {
+			limitedMemory = false
+		}
+		if c.MemoryPercentage > t.MemoryPercentage {
+			t.MemoryPercentage = c.MemoryPercentage
+		}
+		if i == 0 || !g.sharedNetwork {
+			t.NetworkRx += c.NetworkRx
+			t.NetworkTx += c.NetworkTx
+			t.Rates.NetworkRx += c.Rates.NetworkRx
+			t.Rates.NetworkTx += c.Rates.NetworkTx
+		}
+		t.BlockRead += c.BlockRead
+		t.BlockWrite += c.BlockWrite
+		t.Rates.BlockRead += c.Rates.BlockRead
+		t.Rates.BlockWrite += c.Rates.BlockWrite
+		t.PidsCurrent += c.PidsCurrent
+		t.PidsLimit += c.PidsLimit
+		if c.PidsLimit == 0 {
+			limitedPids = false
+		}
+		if !c.Stale {
+			t.Stale = false
+		}
+	}
+	if !limitedMemory {
+		t.MemoryLimit = 0
+	}
+	if !limitedPids {
+		t.PidsLimit = 0
+	}
+	t.PidsPercentage = mstats.PidsPercent(t.PidsCurrent, t.PidsLimit)
+	return t
+}
+
+// groupView is which groups of the table are expanded and which one is
+// selected, changed with the keys when the table is interactive.
+type groupView struct {
+	mu sync.Mutex
+	// expanded are the groups expanded or collapsed with the keys, the
+	// others follow the grouping.
+	expanded map[string]bool
+	// selected is the name of the selected group.
+	selected string
+	// names are the names of the groups of the last update.
+	names       []string
+	interactive bool
+}
+
+func newGroupView() *groupView {
+	return &groupView{expanded: map[string]bool{}}
+}
+
+// isExpanded returns whether the containers of the group are shown.
+func (v *groupView) isExpanded(name string) bool {
+	if e, ok := v.expanded[name]; ok {
+		return e
+	}
+	return containerGrouping == nil || !containerGrouping.collapsed
+}
+
+// setGroups updates the names of the groups, keeping the selection if the
+// group still exists.
+func (v *groupView) setGroups(groups []*containerGroup) {
+	v.names = v.names[:0]
+	found := false
+	for _, g := range groups {
+		if g.name == "" {
+			continue
+		}
+		v.names = append(v.names, g.name)
+		found = found || g.name == v.selected
+	}
+	if !found {
+		v.selected = ""
+		if len(v.names) > 0 {
+			v.selected = v.names[0]
+		}
+	}
+}
+
+// handleKey moves the selection with the up and down keys, toggles the
+// selected group with space or enter, and expands or collapses all the
+// groups with + and -. It returns whether the view changed.
+func (v *groupView) handleKey(k string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch k {
+	case keyUp, keyDown:
+		for i, name := range v.names {
+			if name != v.selected {
+				continue
+			}
+			if k == keyUp && i > 0 {
+				v.selected = v.names[i-1]
+			} else if k == keyDown && i < len(v.names)-1 {
+				v.selected = v.names[i+1]
+			}
+			break
+		}
+	case " ", "\n", "\r":
+		if v.selected == "" {
+			return false
+		}
+		v.expanded[v.selected] = !v.isExpanded(v.selected)
+	case "+", "-":
+		for _, name := range v.names {
+			v.expanded[name] = k == "+"
+		}
+	default:
+		return false
+	}
+	return true
+}
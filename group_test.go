@@ -0,0 +1,271 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGrouping(t *testing.T) {
+	c := &containerStats{
+		ID: "web-1a2b3c",
+		Labels: map[string]string{
+			labelPodName: "web",
+			"app":        "shop",
+		},
+	}
+
+	testCases := []struct {
+		grouping      string
+		key           string
+		sharedNetwork bool
+		expectedErr   string
+	}{
+		{grouping: "label:app", key: "shop"},
+		{grouping: "label:" + labelPodName, key: "web", sharedNetwork: true},
+		{grouping: "label:tier", key: ""},
+		{grouping: "regex:^([a-z]+)-", key: "web"},
+		{grouping: "regex:^[a-z]+", key: "web"},
+		{grouping: "regex:^db-", key: ""},
+		{grouping: "none"},
+		{grouping: "label:", expectedErr: `missing label in group by "label:"`},
+		{grouping: "regex:(", expectedErr: "invalid pattern in group by \"regex:(\": error parsing regexp: missing closing ): `(`"},
+		{grouping: "pod", expectedErr: `unknown group by "pod", must be label:KEY, regex:PATTERN or none`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.grouping, func(t *testing.T) {
+			g, err := parseGrouping(tc.grouping)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.grouping == "none" {
+				if g != nil {
+					t.Fatalf("expected no grouping, got %+v", g)
+				}
+				return
+			}
+			if got := g.key(c); got != tc.key {
+				t.Fatalf("expected group %q, got %q", tc.key, got)
+			}
+			if g.sharedNetwork != tc.sharedNetwork {
+				t.Fatalf("expected shared network %t, got %t", tc.sharedNetwork, g.sharedNetwork)
+			}
+			if !g.collapsed {
+				t.Fatal("expected the groups to be collapsed")
+			}
+		})
+	}
+}
+
+func TestGroupTotal(t *testing.T) {
+	container := func(memory, limit float64, pids, pidsLimit uint64, stale bool) *containerStats {
+		c := &containerStats{
+			CPUPercentage: 10,
+			Memory:        memory,
+			MemoryLimit:   limit,
+			NetworkRx:     100,
+			NetworkTx:     200,
+			BlockRead:     1000,
+			BlockWrite:    2000,
+			PidsCurrent:   pids,
+			PidsLimit:     pidsLimit,
+			Stale:         stale,
+		}
+		if limit > 0 {
+			c.MemoryPercentage = memory / limit * 100
+		}
+		c.Rates.NetworkRx = 1
+		c.Rates.NetworkTx = 2
+		return c
+	}
+
+	testCases := []struct {
+		name          string
+		containers    []*containerStats
+		sharedNetwork bool
+
+		memoryLimit, memoryPercentage, networkRx, pidsPercentage float64
+		pidsLimit                                                uint64
+		stale                                                    bool
+	}{
+		{
+			name:             "limited",
+			containers:       []*containerStats{container(100, 400, 5, 10, false), container(300, 600, 5, 40, true)},
+			memoryLimit:      1000,
+			memoryPercentage: 50,
+			networkRx:        200,
+			pidsLimit:        50,
+			pidsPercentage:   20,
+		},
+		{
+			name:             "shared network",
+			containers:       []*containerStats{container(100, 400, 5, 10, false), container(300, 600, 5, 40, false)},
+			sharedNetwork:    true,
+			memoryLimit:      1000,
+			memoryPercentage: 50,
+			networkRx:        100,
+			pidsLimit:        50,
+			pidsPercentage:   20,
+		},
+		{
+			name:             "no memory limit",
+			containers:       []*containerStats{container(100, 400, 5, 10, false), container(300, 0, 5, 0, false)},
+			memoryPercentage: 25,
+			networkRx:        200,
+		},
+		{
+			name:             "unlimited cgroup v1",
+			containers:       []*containerStats{container(100, 400, 5, 10, false), container(300, 9223372036854771712, 5, 40, false)},
+			memoryPercentage: 25,
+			networkRx:        200,
+			pidsLimit:        50,
+			pidsPercentage:   20,
+		},
+		{
+			name:             "stale",
+			containers:       []*containerStats{container(100, 400, 5, 10, true), container(300, 600, 5, 40, true)},
+			memoryLimit:      1000,
+			memoryPercentage: 50,
+			networkRx:        200,
+			pidsLimit:        50,
+			pidsPercentage:   20,
+			stale:            true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &containerGroup{name: "web", containers: tc.containers, sharedNetwork: tc.sharedNetwork}
+			total := g.total()
+
+			if total.ID != "web" || total.CPUPercentage != 20 || total.Memory != 400 || total.BlockRead != 2000 || total.PidsCurrent != 10 {
+				t.Fatalf("unexpected totals %+v", total)
+			}
+			if total.MemoryLimit != tc.memoryLimit {
+				t.Fatalf("expected memory limit %v, got %v", tc.memoryLimit, total.MemoryLimit)
+			}
+			if total.MemoryPercentage != tc.memoryPercentage {
+				t.Fatalf("expected memory percentage %v, got %v", tc.memoryPercentage, total.MemoryPercentage)
+			}
+			if total.NetworkRx != tc.networkRx {
+				t.Fatalf("expected network rx %v, got %v", tc.networkRx, total.NetworkRx)
+			}
+			if total.PidsLimit != tc.pidsLimit || total.PidsPercentage != tc.pidsPercentage {
+				t.Fatalf("expected pids limit %d at %v%%, got %d at %v%%", tc.pidsLimit, tc.pidsPercentage, total.PidsLimit, total.PidsPercentage)
+			}
+			if total.Stale != tc.stale {
+				t.Fatalf("expected stale %t, got %t", tc.stale, total.Stale)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	defer func(g *grouping) { containerGrouping = g }(containerGrouping)
+	var err error
+	if containerGrouping, err = parseGrouping("label:app"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newCaptureStats()
+	for id, app := range map[string]string{"a1": "web", "a2": "web", "b1": "db", "c1": ""} {
+		c := s.container(id)
+		c.Labels = map[string]string{"app": app}
+	}
+
+	var got []string
+	for _, g := range s.groups() {
+		names := g.name + ":"
+		for _, c := range g.containers {
+			names += " " + c.ID
+		}
+		got = append(got, names)
+	}
+	expected := []string{": c1", "db: b1", "web: a1 a2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected groups %q, got %q", expected, got)
+	}
+}
+
+func TestGroupView(t *testing.T) {
+	defer func(g *grouping) { containerGrouping = g }(containerGrouping)
+	containerGrouping = &grouping{key: podName, collapsed: true}
+
+	groups := func(names ...string) []*containerGroup {
+		var groups []*containerGroup
+		for _, name := range names {
+			groups = append(groups, &containerGroup{name: name})
+		}
+		return groups
+	}
+
+	v := newGroupView()
+	v.setGroups(groups("", "a", "b", "c"))
+	if v.selected != "a" {
+		t.Fatalf("expected the first group to be selected, got %q", v.selected)
+	}
+
+	steps := []struct {
+		key      string
+		changed  bool
+		selected string
+		expanded []string
+	}{
+		{key: keyUp, changed: true, selected: "a"},
+		{key: keyDown, changed: true, selected: "b"},
+		{key: " ", changed: true, selected: "b", expanded: []string{"b"}},
+		{key: keyDown, changed: true, selected: "c", expanded: []string{"b"}},
+		{key: keyDown, changed: true, selected: "c", expanded: []string{"b"}},
+		{key: "\n", changed: true, selected: "c", expanded: []string{"b", "c"}},
+		{key: "\r", changed: true, selected: "c", expanded: []string{"b"}},
+		{key: "+", changed: true, selected: "c", expanded: []string{"a", "b", "c"}},
+		{key: "x", changed: false, selected: "c", expanded: []string{"a", "b", "c"}},
+		{key: "-", changed: true, selected: "c"},
+	}
+	for i, step := range steps {
+		if changed := v.handleKey(step.key); changed != step.changed {
+			t.Fatalf("step %d: expected changed %t, got %t", i, step.changed, changed)
+		}
+		if v.selected != step.selected {
+			t.Fatalf("step %d: expected %q to be selected, got %q", i, step.selected, v.selected)
+		}
+		var expanded []string
+		for _, name := range v.names {
+			if v.isExpanded(name) {
+				expanded = append(expanded, name)
+			}
+		}
+		if !reflect.DeepEqual(expanded, step.expanded) {
+			t.Fatalf("step %d: expected %v to be expanded, got %v", i, step.expanded, expanded)
+		}
+	}
+
+	// The selection is kept while its group exists, and the groups toggled
+	// with the keys stay toggled.
+	v.handleKey(" ")
+	v.setGroups(groups("b", "c", "d"))
+	if v.selected != "c" || !v.isExpanded("c") || v.isExpanded("d") {
+		t.Fatalf("expected c to stay selected and expanded, got %q", v.selected)
+	}
+	v.setGroups(groups("d"))
+	if v.selected != "d" {
+		t.Fatalf("expected the first group to be selected once c is gone, got %q", v.selected)
+	}
+
+	// Without a selection the toggle keys do nothing, and the groups follow
+	// the grouping unless toggled.
+	v.setGroups(nil)
+	if v.handleKey(" ") {
+		t.Fatal("expected no change without a selected group")
+	}
+	containerGrouping.collapsed = false
+	if !v.isExpanded("e") {
+		t.Fatal("expected the groups of an expanded grouping to be expanded")
+	}
+}
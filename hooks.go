@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The events hooks can be run on.
+const (
+	hookFiring    = "firing"
+	hookResolved  = "resolved"
+	hookOOM       = "oom"
+	hookAppear    = "appear"
+	hookDisappear = "disappear"
+)
+
+var hookEvents = map[string]bool{
+	hookFiring:    true,
+	hookResolved:  true,
+	hookOOM:       true,
+	hookAppear:    true,
+	hookDisappear: true,
+}
+
+// lifecycleEvent is emitted when a container appears in the events stream,
+// including when a stale container receives samples again, and when it
+// disappears by becoming stale.
+type lifecycleEvent struct {
+	Time      time.Time
+	Container string
+	// Event is either appear or disappear.
+	Event string
+}
+
+// hookContext is the context passed to the hooks as JSON on stdin.
+type hookContext struct {
+	Event     string          `json:"event"`
+	Time      time.Time       `json:"time"`
+	Container string          `json:"container"`
+	Name      string          `json:"name,omitempty"`
+	Rule      string          `json:"rule,omitempty"`
+	Value     float64         `json:"value,omitempty"`
+	Threshold float64         `json:"threshold,omitempty"`
+	Stats     *containerStats `json:"stats,omitempty"`
+}
+
+// env returns the environment variables passed to the hooks.
+func (h hookContext) env() []string {
+	env := []string{
+		"MAGNETO_EVENT=" + h.Event,
+		"MAGNETO_TIME=" + h.Time.Format(time.RFC3339),
+		"MAGNETO_CONTAINER_ID=" + h.Container,
+		"MAGNETO_CONTAINER_NAME=" + h.Name,
+	}
+	if h.Rule != "" {
+		env = append(env,
+			"MAGNETO_RULE="+h.Rule,
+			"MAGNETO_VALUE="+formatHookValue(h.Value),
+			"MAGNETO_THRESHOLD="+formatHookValue(h.Threshold),
+		)
+	}
+	if c := h.Stats; c != nil {
+		env = append(env,
+			"MAGNETO_CPU_PERCENT="+formatHookValue(c.CPUPercentage),
+			"MAGNETO_MEMORY="+formatHookValue(c.Memory),
+			"MAGNETO_MEMORY_LIMIT="+formatHookValue(c.MemoryLimit),
+			"MAGNETO_MEMORY_PERCENT="+formatHookValue(c.MemoryPercentage),
+			"MAGNETO_PIDS="+strconv.FormatUint(c.PidsCurrent, 10),
+			"MAGNETO_LAST_SAMPLE="+c.LastSample.Format(time.RFC3339),
+		)
+	}
+	return env
+}
+
+func formatHookValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// hook is a command run with the shell on some of the hook events.
+type hook struct {
+	command string
+	// events are the events the hook runs on, all of them if empty.
+	events map[string]bool
+}
+
+// parseHook parses a hook in the format [event,...:]command.
+func parseHook(s string) (*hook, error) {
+	h := &hook{command: s}
+	if i := strings.Index(s, ":"); i > 0 {
+		events := map[string]bool{}
+		for _, e := range strings.Split(s[:i], ",") {
+			if !hookEvents[strings.TrimSpace(e)] {
+				events = nil
+				break
+			}
+			events[strings.TrimSpace(e)] = true
+		}
+		if events != nil {
+			h.command, h.events = strings.TrimSpace(s[i+1:]), events
+		}
+	}
+	if h.command == "" {
+		return nil, fmt.Errorf("invalid hook %q, missing the command", s)
+	}
+	return h, nil
+}
+
+func (h *hook) runsOn(event string) bool {
+	return len(h.events) == 0 || h.events[event]
+}
+
+// hookRunner runs the hooks, at most limit at a time.
+type hookRunner struct {
+	s       *stats
+	hooks   []*hook
+	timeout time.Duration
+	sem     chan struct{}
+	wg      sync.WaitGroup
+
+	// mu guards closed, set when waiting for the hooks so no more are
+	// started.
+	mu     sync.Mutex
+	closed bool
+}
+
+// addHooks runs the hooks on the rule and lifecycle events.
+func (s *stats) addHooks(hooks []*hook, timeout time.Duration, limit int) {
+	if len(hooks) == 0 {
+		return
+	}
+	r := &hookRunner{
+		s:       s,
+		hooks:   hooks,
+		timeout: timeout,
+		sem:     make(chan struct{}, limit),
+	}
+	s.ruleListeners = append(s.ruleListeners, r.ruleEvent)
+	s.lifecycleListeners = append(s.lifecycleListeners, r.lifecycleEvent)
+	s.closers = append(s.closers, r.wait)
+}
+
+func (r *hookRunner) ruleEvent(e ruleEvent) {
+	event := e.State
+	if e.Rule == ruleOOM {
+		event = hookOOM
+	}
+	if event != hookFiring && event != hookResolved && event != hookOOM {
+		return
+	}
+
+	r.run(hookContext{
+		Event:     event,
+		Time:      e.Time,
+		Container: e.Container,
+		Rule:      e.Rule,
+		Value:     e.Value,
+		Threshold: e.Threshold,
+	})
+}
+
+func (r *hookRunner) lifecycleEvent(e lifecycleEvent) {
+	r.run(hookContext{
+		Event:     e.Event,
+		Time:      e.Time,
+		Container: e.Container,
+	})
+}
+
+// run starts the hooks for the event. The statistics of the container and the
+// context are read right away, the hooks wait for a free slot if the limit of
+// running hooks is reached.
+func (r *hookRunner) run(h hookContext) {
+	h.Stats = r.s.snapshot(h.Container)
+	if h.Stats != nil {
+		h.Name = h.Stats.Name
+	}
+	input, err := json.Marshal(h)
+	if err != nil {
+		logrus.Errorf("encoding the hook context failed: %v", err)
+		return
+	}
+	env := append(os.Environ(), h.env()...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	for _, hk := range r.hooks {
+		if !hk.runsOn(h.Event) {
+			continue
+		}
+		r.wg.Add(1)
+		go func(hk *hook) {
+			defer r.wg.Done()
+			r.sem <- struct{}{}
+			defer func() { <-r.sem }()
+
+			if err := runHook(hk.command, env, input, r.timeout); err != nil {
+				logrus.Errorf("running %s hook for container %s failed: %v", h.Event, h.Container, err)
+			}
+		}(hk)
+	}
+}
+
+// wait waits for the running hooks to finish. Hooks are not started
+// anymore once it was called.
+func (r *hookRunner) wait() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// runHook runs the command with the shell in its own process group, so the
+// processes it started are killed along with it when it times out.
+func runHook(command string, env []string, input []byte, timeout time.Duration) error {
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out.Bytes()))
+		}
+		logrus.Debugf("hook %q: %s", command, bytes.TrimSpace(out.Bytes()))
+		return nil
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// snapshot returns a copy of the statistics of the container, nil if it has
+// not been seen.
+func (s *stats) snapshot(id string) *containerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.containers[id]
+	if !ok {
+		return nil
+	}
+	cp := *c
+	cp.Alerts = append([]string(nil), c.Alerts...)
+	cp.Hugetlb = append([]hugetlbStats(nil), c.Hugetlb...)
+	return &cp
+}
+
+// emitLifecycleEvent logs the lifecycle event and sends it to the listeners.
+func (s *stats) emitLifecycleEvent(e lifecycleEvent) {
+	if s.replaying {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"container": e.Container,
+		"event":     e.Event,
+	}).Debugf("container %s: %s", e.Container, e.Event)
+
+	for _, l := range s.lifecycleListeners {
+		l(e)
+	}
+}
+
+// hookFlag collects the hooks passed with the repeatable hook flag.
+type hookFlag []*hook
+
+func (f *hookFlag) String() string {
+	commands := make([]string, 0, len(*f))
+	for _, h := range *f {
+		commands = append(commands, h.command)
+	}
+	return strings.Join(commands, ", ")
+}
+
+func (f *hookFlag) Set(s string) error {
+	h, err := parseHook(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, h)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHookRunnerWaitStopsHooks(t *testing.T) {
+	f, err := ioutil.TempFile("", "magneto-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	r := &hookRunner{
+		s:       newStats(),
+		hooks:   []*hook{{command: "echo >> " + f.Name()}},
+		timeout: 5 * time.Second,
+		sem:     make(chan struct{}, 2),
+	}
+	appear := lifecycleEvent{Time: time.Unix(1000, 0), Container: "abc", Event: hookAppear}
+
+	// Waiting while hooks are started must not race with them.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.lifecycleEvent(appear)
+		}()
+	}
+	r.wait()
+	wg.Wait()
+
+	ran, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.lifecycleEvent(appear)
+	time.Sleep(100 * time.Millisecond)
+	after, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(ran) {
+		t.Fatalf("expected no hook to run after waiting, %d ran before and %d after", len(ran), len(after))
+	}
+}
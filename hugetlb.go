@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	units "github.com/docker/go-units"
+	"github.com/genuinetools/magneto/types"
+)
+
+// hugetlbStats holds the huge pages statistics for a single page size.
+type hugetlbStats struct {
+	PageSize   string  `json:"page_size"`
+	Usage      uint64  `json:"usage"`
+	Max        uint64  `json:"max"`
+	Limit      uint64  `json:"limit"`
+	Percentage float64 `json:"percentage"`
+	Failcnt    uint64  `json:"failcnt"`
+	// FailcntIncrease is the number of allocation failures since the first
+	// sample of the container.
+	FailcntIncrease uint64 `json:"failcnt_increase"`
+	baseFailcnt     uint64
+}
+
+// calculateHugetlb returns the huge pages statistics ordered by page size.
+// The runc events do not carry the hugetlb limit, so the limit is taken from
+// the cgroup configuration of the container when it is known.
+func calculateHugetlb(hugetlb map[string]types.Hugetlb, limits map[string]uint64, previous []hugetlbStats) []hugetlbStats {
+	baseFailcnt := make(map[string]uint64, len(previous))
+	for _, h := range previous {
+		baseFailcnt[h.PageSize] = h.baseFailcnt
+	}
+
+	pageSizes := make([]string, 0, len(hugetlb))
+	for pageSize := range hugetlb {
+		pageSizes = append(pageSizes, pageSize)
+	}
+	sort.Slice(pageSizes, func(i, j int) bool {
+		return pageSizeBytes(pageSizes[i]) < pageSizeBytes(pageSizes[j])
+	})
+
+	h := make([]hugetlbStats, 0, len(pageSizes))
+	for _, pageSize := range pageSizes {
+		v := hugetlb[pageSize]
+		s := hugetlbStats{
+			PageSize:    pageSize,
+			Usage:       v.Usage,
+			Max:         v.Max,
+			Limit:       limits[pageSize],
+			Failcnt:     v.Failcnt,
+			baseFailcnt: v.Failcnt,
+		}
+		if s.Limit != 0 {
+			s.Percentage = float64(s.Usage) / float64(s.Limit) * 100.0
+		}
+		if base, ok := baseFailcnt[pageSize]; ok && s.Failcnt >= base {
+			s.baseFailcnt = base
+			s.FailcntIncrease = s.Failcnt - base
+		}
+		h = append(h, s)
+	}
+	return h
+}
+
+// pageSizeBytes returns the size in bytes of a hugetlb page size such as
+// "2MB" or "1GB", used to order the page sizes.
+func pageSizeBytes(pageSize string) int64 {
+	size, err := units.RAMInBytes(strings.TrimSuffix(pageSize, "B"))
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// DisplayHugetlb writes the huge pages section below the table. Only the
+// page sizes in use, limited or with allocation failures are shown unless all
+// is set.
+func (s *stats) DisplayHugetlb(w io.Writer, all bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rows []string
+	for _, c := range s.sorted() {
+		for _, h := range c.Hugetlb {
+			if !all && h.Usage == 0 && h.Limit == 0 && h.Failcnt == 0 {
+				continue
+			}
+
+			limit := "-"
+			if h.Limit != 0 {
+				limit = units.BytesSize(float64(h.Limit))
+			}
+			failcnt := fmt.Sprintf("%d", h.Failcnt)
+			if h.FailcntIncrease > 0 {
+				failcnt = fmt.Sprintf("%s%d (+%d)%s", colorRed, h.Failcnt, h.FailcntIncrease, colorReset)
+			}
+			rows = append(rows, fmt.Sprintf("%s\t%s\t%s / %s\t%.2f%%\t%s\t%s\n",
+				c.displayName(),
+				h.PageSize,
+				units.BytesSize(float64(h.Usage)), limit,
+				h.Percentage,
+				units.BytesSize(float64(h.Max)),
+				failcnt))
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	io.WriteString(w, "\nCONTAINER\tHUGETLB PAGE SIZE\tUSAGE / LIMIT\tUSAGE %\tMAX USAGE\tFAILCNT\n")
+	for _, row := range rows {
+		io.WriteString(w, row)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func TestCalculateHugetlb(t *testing.T) {
+	testCases := []struct {
+		name    string
+		samples []map[string]types.Hugetlb
+		limits  map[string]uint64
+		want    []hugetlbStats
+	}{
+		{
+			name: "ordered by page size",
+			samples: []map[string]types.Hugetlb{{
+				"1GB":  {Usage: 1 << 30, Max: 1 << 30},
+				"64KB": {},
+				"2MB":  {Usage: 4 << 20, Max: 8 << 20},
+			}},
+			limits: map[string]uint64{"2MB": 16 << 20},
+			want: []hugetlbStats{
+				{PageSize: "64KB"},
+				{PageSize: "2MB", Usage: 4 << 20, Max: 8 << 20, Limit: 16 << 20, Percentage: 25},
+				{PageSize: "1GB", Usage: 1 << 30, Max: 1 << 30},
+			},
+		},
+		{
+			name: "first sample",
+			samples: []map[string]types.Hugetlb{
+				{"2MB": {Usage: 2 << 20, Failcnt: 3}},
+			},
+			want: []hugetlbStats{
+				{PageSize: "2MB", Usage: 2 << 20, Failcnt: 3, baseFailcnt: 3},
+			},
+		},
+		{
+			name: "failcnt increase",
+			samples: []map[string]types.Hugetlb{
+				{"2MB": {Failcnt: 3}},
+				{"2MB": {Failcnt: 5}},
+				{"2MB": {Failcnt: 8}},
+			},
+			want: []hugetlbStats{
+				{PageSize: "2MB", Failcnt: 8, FailcntIncrease: 5, baseFailcnt: 3},
+			},
+		},
+		{
+			name: "failcnt reset",
+			samples: []map[string]types.Hugetlb{
+				{"2MB": {Failcnt: 5}},
+				{"2MB": {Failcnt: 1}},
+				{"2MB": {Failcnt: 2}},
+			},
+			want: []hugetlbStats{
+				{PageSize: "2MB", Failcnt: 2, FailcntIncrease: 1, baseFailcnt: 1},
+			},
+		},
+		{
+			name: "new page size",
+			samples: []map[string]types.Hugetlb{
+				{"2MB": {Failcnt: 1}},
+				{"2MB": {Failcnt: 2}, "1GB": {Failcnt: 4}},
+			},
+			want: []hugetlbStats{
+				{PageSize: "2MB", Failcnt: 2, FailcntIncrease: 1, baseFailcnt: 1},
+				{PageSize: "1GB", Failcnt: 4, baseFailcnt: 4},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []hugetlbStats
+			for _, sample := range tc.samples {
+				got = calculateHugetlb(sample, tc.limits, got)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPageSizeBytes(t *testing.T) {
+	testCases := []struct {
+		pageSize string
+		want     int64
+	}{
+		{"64KB", 64 << 10},
+		{"2MB", 2 << 20},
+		{"32MB", 32 << 20},
+		{"1GB", 1 << 30},
+		{"invalid", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pageSize, func(t *testing.T) {
+			if got := pageSizeBytes(tc.pageSize); got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	mstats "github.com/genuinetools/magneto/stats"
+	"github.com/genuinetools/magneto/types"
+)
+
+// intelRdtStats holds the Intel RDT cache allocation and the resctrl
+// monitoring data of a container.
+type intelRdtStats struct {
+	L3CacheSchema     string `json:"l3_cache_schema,omitempty"`
+	L3CacheSchemaRoot string `json:"l3_cache_schema_root,omitempty"`
+	// L3CacheAllocation is the percentage of the L3 cache ways in the root
+	// schema that are allocated to the container.
+	L3CacheAllocation float64 `json:"l3_cache_allocation"`
+	// LLCOccupancy is the last level cache occupancy in bytes.
+	LLCOccupancy uint64 `json:"llc_occupancy"`
+	// MemoryBandwidth is the total memory bandwidth in bytes per second.
+	MemoryBandwidth float64 `json:"memory_bandwidth"`
+	mbmTotal        uint64
+	previousRead    time.Time
+}
+
+// calculateIntelRdt returns the Intel RDT statistics from the sample and the
+// resctrl monitoring data of the container read at now.
+func calculateIntelRdt(rdt types.IntelRdt, state *types.State, previous intelRdtStats, now time.Time) intelRdtStats {
+	s := intelRdtStats{
+		L3CacheSchema:     rdt.L3CacheSchema,
+		L3CacheSchemaRoot: rdt.L3CacheSchemaRoot,
+		L3CacheAllocation: calculateL3CacheAllocation(rdt.L3CacheSchema, rdt.L3CacheSchemaRoot),
+	}
+
+	if state == nil || state.IntelRdtPath == "" {
+		return s
+	}
+
+	llcOccupancy, mbmTotal, err := readResctrlMonData(state.IntelRdtPath)
+	if err != nil {
+		return s
+	}
+	s.LLCOccupancy = llcOccupancy
+	s.mbmTotal = mbmTotal
+	s.previousRead = now
+
+	if elapsed := now.Sub(previous.previousRead).Seconds(); !previous.previousRead.IsZero() && elapsed > 0 {
+		s.MemoryBandwidth = mstats.CounterDelta(mbmTotal, previous.mbmTotal) / elapsed
+	}
+	return s
+}
+
+// calculateL3CacheAllocation returns the percentage of the cache ways of the
+// root schema that the container schema allocates, summed over all the cache
+// domains. Schemas are in the resctrl format "L3:0=fffff;1=fffff".
+func calculateL3CacheAllocation(schema, rootSchema string) float64 {
+	var (
+		allocated = countL3CacheWays(schema)
+		total     = countL3CacheWays(rootSchema)
+	)
+	if total == 0 {
+		return 0
+	}
+	return float64(allocated) / float64(total) * 100.0
+}
+
+func countL3CacheWays(schema string) int {
+	var ways int
+	for _, line := range strings.Split(schema, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "L3:") {
+			continue
+		}
+		for _, domain := range strings.Split(strings.TrimPrefix(line, "L3:"), ";") {
+			parts := strings.SplitN(domain, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			mask, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 16, 64)
+			if err != nil {
+				continue
+			}
+			ways += bits.OnesCount64(mask)
+		}
+	}
+	return ways
+}
+
+// readResctrlMonData sums the llc_occupancy and mbm_total_bytes of every
+// monitoring domain in the mon_data directory of a resctrl group.
+func readResctrlMonData(path string) (uint64, uint64, error) {
+	domains, err := ioutil.ReadDir(filepath.Join(path, "mon_data"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var llcOccupancy, mbmTotal uint64
+	for _, domain := range domains {
+		if !domain.IsDir() {
+			continue
+		}
+		dir := filepath.Join(path, "mon_data", domain.Name())
+
+		v, err := readResctrlValue(filepath.Join(dir, "llc_occupancy"))
+		if err != nil {
+			return 0, 0, err
+		}
+		llcOccupancy += v
+
+		v, err = readResctrlValue(filepath.Join(dir, "mbm_total_bytes"))
+		if err != nil {
+			return 0, 0, err
+		}
+		mbmTotal += v
+	}
+	return llcOccupancy, mbmTotal, nil
+}
+
+// readResctrlValue reads a resctrl monitoring file. Files for events that are
+// not supported by the hardware do not exist and are read as 0.
+func readResctrlValue(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	// The kernel reports "Unavailable" when the counter cannot be read.
+	if s == "Unavailable" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to convert value %s in %s to int: %v", s, path, err)
+	}
+	return v, nil
+}
+
+// DisplayIntelRdt writes the Intel RDT section for the containers that have a
+// cache allocation or monitoring data.
+func (s *stats) DisplayIntelRdt(w io.Writer) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rows []string
+	for _, c := range s.sorted() {
+		r := c.IntelRdt
+		if r.L3CacheSchema == "" && r.L3CacheSchemaRoot == "" && r.LLCOccupancy == 0 && r.mbmTotal == 0 {
+			continue
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%.2f%%\t%s\t%s/s\n",
+			c.displayName(),
+			formatL3CacheSchema(r.L3CacheSchema),
+			formatL3CacheSchema(r.L3CacheSchemaRoot),
+			r.L3CacheAllocation,
+			units.BytesSize(float64(r.LLCOccupancy)),
+			units.HumanSizeWithPrecision(r.MemoryBandwidth, 3)))
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	io.WriteString(w, "\nCONTAINER\tL3 SCHEMA\tL3 ROOT SCHEMA\tL3 ALLOCATED %\tLLC OCCUPANCY\tMEM BANDWIDTH\n")
+	for _, row := range rows {
+		io.WriteString(w, row)
+	}
+}
+
+// formatL3CacheSchema returns the L3 line of a schema on a single line.
+func formatL3CacheSchema(schema string) string {
+	for _, line := range strings.Split(schema, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "L3:") {
+			return strings.TrimPrefix(line, "L3:")
+		}
+	}
+	if schema == "" {
+		return "-"
+	}
+	return strings.TrimSpace(schema)
+}
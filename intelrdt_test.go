@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func TestCalculateL3CacheAllocation(t *testing.T) {
+	testCases := []struct {
+		name       string
+		schema     string
+		rootSchema string
+		want       float64
+	}{
+		{"empty", "", "", 0},
+		{"no root schema", "L3:0=f", "", 0},
+		{"full", "L3:0=fffff", "L3:0=fffff", 100},
+		{"quarter", "L3:0=1f", "L3:0=fffff", 25},
+		{"two domains", "L3:0=ff;1=3", "L3:0=ff;1=ff", 62.5},
+		{"other resources", "MB:0=100\nL3:0=f", "MB:0=100\nL3:0=ff", 50},
+		{"invalid mask", "L3:0=zz;1=f", "L3:0=ff;1=ff", 25},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := calculateL3CacheAllocation(tc.schema, tc.rootSchema); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// writeResctrlTree writes a fake resctrl group with a mon_data directory
+// holding the files of the domains.
+func writeResctrlTree(t *testing.T, domains map[string]map[string]string) string {
+	dir, err := ioutil.TempDir("", "magneto-resctrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for domain, files := range domains {
+		d := filepath.Join(dir, "mon_data", domain)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(d, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestReadResctrlMonData(t *testing.T) {
+	testCases := []struct {
+		name         string
+		domains      map[string]map[string]string
+		llcOccupancy uint64
+		mbmTotal     uint64
+		wantErr      bool
+	}{
+		{
+			name: "two domains",
+			domains: map[string]map[string]string{
+				"mon_L3_00": {"llc_occupancy": "1024\n", "mbm_total_bytes": "4096\n"},
+				"mon_L3_01": {"llc_occupancy": "2048\n", "mbm_total_bytes": "1000\n"},
+			},
+			llcOccupancy: 3072,
+			mbmTotal:     5096,
+		},
+		{
+			name: "unsupported event",
+			domains: map[string]map[string]string{
+				"mon_L3_00": {"llc_occupancy": "1024\n"},
+			},
+			llcOccupancy: 1024,
+		},
+		{
+			name: "unavailable counter",
+			domains: map[string]map[string]string{
+				"mon_L3_00": {"llc_occupancy": "Unavailable\n", "mbm_total_bytes": "10\n"},
+			},
+			mbmTotal: 10,
+		},
+		{
+			name: "malformed counter",
+			domains: map[string]map[string]string{
+				"mon_L3_00": {"llc_occupancy": "many\n"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeResctrlTree(t, tc.domains)
+			defer os.RemoveAll(dir)
+
+			llcOccupancy, mbmTotal, err := readResctrlMonData(dir)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if llcOccupancy != tc.llcOccupancy || mbmTotal != tc.mbmTotal {
+				t.Fatalf("expected %d and %d, got %d and %d", tc.llcOccupancy, tc.mbmTotal, llcOccupancy, mbmTotal)
+			}
+		})
+	}
+}
+
+func TestCalculateIntelRdtBandwidth(t *testing.T) {
+	dir := writeResctrlTree(t, map[string]map[string]string{
+		"mon_L3_00": {"llc_occupancy": "1024", "mbm_total_bytes": "1000"},
+	})
+	defer os.RemoveAll(dir)
+
+	state := &types.State{IntelRdtPath: dir}
+	now := time.Unix(1000, 0)
+
+	first := calculateIntelRdt(types.IntelRdt{}, state, intelRdtStats{}, now)
+	if first.LLCOccupancy != 1024 || first.MemoryBandwidth != 0 {
+		t.Fatalf("unexpected first sample %+v", first)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "mon_data", "mon_L3_00", "mbm_total_bytes"), []byte("5000"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := calculateIntelRdt(types.IntelRdt{}, state, first, now.Add(2*time.Second))
+	if second.MemoryBandwidth != 2000 {
+		t.Fatalf("expected a bandwidth of 2000 bytes/s, got %v", second.MemoryBandwidth)
+	}
+}
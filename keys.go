@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Keys that are read as escape sequences from the terminal.
+const (
+	keyLeft  = "left"
+	keyRight = "right"
+	keyUp    = "up"
+	keyDown  = "down"
+)
+
+// keyReader reads single key presses from a terminal.
+type keyReader struct {
+	f        *os.File
+	fd       int
+	oldState unix.Termios
+}
+
+// newKeyReader puts the terminal in cbreak mode so key presses are read
+// without waiting for a newline or being echoed. It returns nil if f is not
+// a terminal.
+func newKeyReader(f *os.File) *keyReader {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil
+	}
+
+	k := &keyReader{f: f, fd: fd, oldState: *termios}
+
+	// Unlike raw mode, the output processing and signals are left alone so
+	// the display and ^C keep working.
+	termios.Lflag &^= unix.ECHO | unix.ICANON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil
+	}
+
+	return k
+}
+
+// keys returns a channel the key presses are sent on. The arrow keys are sent
+// as keyLeft, keyRight, keyUp and keyDown.
+func (k *keyReader) keys() <-chan string {
+	c := make(chan string)
+	go func() {
+		defer close(c)
+		buf := make([]byte, 16)
+		for {
+			n, err := k.f.Read(buf)
+			if err != nil {
+				return
+			}
+			for i := 0; i < n; i++ {
+				// Arrow keys are sent as ESC [ A to ESC [ D.
+				if buf[i] == 0x1b && i+2 < n && buf[i+1] == '[' {
+					switch buf[i+2] {
+					case 'A':
+						c <- keyUp
+					case 'B':
+						c <- keyDown
+					case 'C':
+						c <- keyRight
+					case 'D':
+						c <- keyLeft
+					}
+					i += 2
+					continue
+				}
+				c <- string(buf[i])
+			}
+		}
+	}()
+	return c
+}
+
+// restore puts the terminal back in the state it was in before.
+func (k *keyReader) restore() error {
+	return unix.IoctlSetTermios(k.fd, unix.TCSETS, &k.oldState)
+}
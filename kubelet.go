@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// kubeletTimeout is the timeout of a request to the kubelet.
+	kubeletTimeout = 10 * time.Second
+
+	// The labels the kubelet sets on the containers of a pod.
+	labelPodNamespace = "io.kubernetes.pod.namespace"
+	labelPodName      = "io.kubernetes.pod.name"
+	labelPodUID       = "io.kubernetes.pod.uid"
+	labelContainer    = "io.kubernetes.container.name"
+)
+
+// kubeletSummary is the part of the kubelet /stats/summary response magneto
+// uses.
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			UID       string `json:"uid"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name string `json:"name"`
+			CPU  *struct {
+				Time                 time.Time `json:"time"`
+				UsageCoreNanoSeconds *uint64   `json:"usageCoreNanoSeconds"`
+			} `json:"cpu"`
+			Memory *struct {
+				AvailableBytes  *uint64 `json:"availableBytes"`
+				UsageBytes      *uint64 `json:"usageBytes"`
+				WorkingSetBytes *uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"containers"`
+		Network *struct {
+			RxBytes *uint64 `json:"rxBytes"`
+			TxBytes *uint64 `json:"txBytes"`
+		} `json:"network"`
+	} `json:"pods"`
+}
+
+// kubeletSource polls the stats summary of a kubelet at the refresh
+// interval. A container is only sampled when the kubelet updated its stats,
+// so the cpu usage is not calculated over two identical samples.
+type kubeletSource struct {
+	name   string
+	url    string
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	ticker *time.Ticker
+
+	// pending are the events of the last poll not returned yet.
+	pending []event
+	// sampled is the time of the cpu stats of the containers last sampled.
+	sampled   map[string]time.Time
+	malformed uint64
+}
+
+// newKubeletSource polls the stats summary at url, like
+// http://127.0.0.1:10255/stats/summary. The path defaults to /stats/summary.
+func newKubeletSource(name, rawurl string) (*kubeletSource, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/stats/summary"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &kubeletSource{
+		name:    name,
+		url:     u.String(),
+		client:  &http.Client{Timeout: kubeletTimeout},
+		ctx:     ctx,
+		cancel:  cancel,
+		sampled: map[string]time.Time{},
+	}
+
+	// Fail early if the kubelet cannot be reached at all.
+	if err := s.poll(); err != nil {
+		cancel()
+		return nil, err
+	}
+	s.ticker = time.NewTicker(interval)
+	return s, nil
+}
+
+func (s *kubeletSource) Name() string      { return s.name }
+func (s *kubeletSource) Malformed() uint64 { return s.malformed }
+
+// Read returns the events of the last poll, polling again at the refresh
+// interval. A failed poll is logged and retried.
+func (s *kubeletSource) Read() (event, error) {
+	for len(s.pending) == 0 {
+		select {
+		case <-s.ticker.C:
+		case <-s.ctx.Done():
+			return event{}, io.EOF
+		}
+		if err := s.poll(); err != nil {
+			logrus.Warn(err)
+		}
+	}
+
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	return e, nil
+}
+
+// Close stops polling.
+func (s *kubeletSource) Close() error {
+	s.cancel()
+	return nil
+}
+
+// poll requests the stats summary and queues the containers whose stats
+// were updated since the last poll.
+func (s *kubeletSource) poll() error {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return fmt.Errorf("requesting the kubelet stats summary failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("requesting the kubelet stats summary failed: %s", resp.Status)
+	}
+
+	var summary kubeletSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		s.malformed++
+		return fmt.Errorf("decoding the kubelet stats summary failed: %v", err)
+	}
+	s.pending = append(s.pending, summary.events(s.sampled)...)
+	return nil
+}
+
+// events converts the containers of the summary to runc stats events, the
+// cumulative cpu usage and the working set memory are used like the cpu
+// usage and the memory without the page cache of runc. The containers of a
+// pod share its network namespace, so they all get the network of the pod.
+// Only the containers whose cpu stats are newer than in sampled are
+// converted, sampled is updated.
+func (summary *kubeletSummary) events(sampled map[string]time.Time) []event {
+	var events []event
+	for _, pod := range summary.Pods {
+		var network []*types.NetworkInterface
+		if n := pod.Network; n != nil && n.RxBytes != nil && n.TxBytes != nil {
+			network = []*types.NetworkInterface{{Name: "eth0", RxBytes: *n.RxBytes, TxBytes: *n.TxBytes}}
+		}
+
+		for _, c := range pod.Containers {
+			if c.CPU == nil || c.CPU.UsageCoreNanoSeconds == nil || c.Memory == nil || c.Memory.WorkingSetBytes == nil {
+				continue
+			}
+
+			id := pod.PodRef.Namespace + "/" + pod.PodRef.Name + "/" + c.Name
+			if !c.CPU.Time.After(sampled[id]) {
+				continue
+			}
+			sampled[id] = c.CPU.Time
+
+			var v types.Stats
+			v.CPU.Usage.Total = *c.CPU.UsageCoreNanoSeconds
+			// The cpu usage is over the time between the samples of the
+			// kubelet, not the time between the polls, so the system usage
+			// is the time of the sample, counted for a single cpu so the
+			// percentage is of one cpu like with runc.
+			v.CPU.Usage.Percpu = make([]uint64, 1)
+			v.Memory.Usage.Usage = *c.Memory.WorkingSetBytes
+			// The kubelet only reports the memory available below the
+			// limit, if there is one.
+			if c.Memory.AvailableBytes != nil {
+				v.Memory.Usage.Limit = *c.Memory.WorkingSetBytes + *c.Memory.AvailableBytes
+			}
+			v.NetworkInterfaces = network
+
+			events = append(events, event{
+				Type: "stats",
+				ID:   id,
+				Name: c.Name,
+				Labels: map[string]string{
+					labelPodNamespace: pod.PodRef.Namespace,
+					labelPodName:      pod.PodRef.Name,
+					labelPodUID:       pod.PodRef.UID,
+					labelContainer:    c.Name,
+				},
+				Data:        v,
+				systemUsage: uint64(c.CPU.Time.UnixNano()),
+			})
+		}
+	}
+	return events
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sliceSource returns the events, then io.EOF.
+type sliceSource struct {
+	events []event
+}
+
+func (s *sliceSource) Name() string      { return "slice" }
+func (s *sliceSource) Malformed() uint64 { return 0 }
+func (s *sliceSource) Close() error      { return nil }
+
+func (s *sliceSource) Read() (event, error) {
+	if len(s.events) == 0 {
+		return event{}, io.EOF
+	}
+	e := s.events[0]
+	s.events = s.events[1:]
+	return e, nil
+}
+
+func TestKubeletSourceCPU(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The cpu usage of the container at the times of the kubelet samples,
+	// 1.5 cpus between the first two and the same sample polled again.
+	samples := []struct {
+		at  time.Duration
+		cpu uint64
+	}{
+		{0, 10e9},
+		{10 * time.Second, 25e9},
+		{10 * time.Second, 25e9},
+	}
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/summary" {
+			http.NotFound(w, r)
+			return
+		}
+		s := samples[polls]
+		if polls < len(samples)-1 {
+			polls++
+		}
+		fmt.Fprintf(w, `{"pods": [{"podRef": {"name": "web-1", "namespace": "default", "uid": "u1"},
+"containers": [{"name": "nginx", "cpu": {"time": %q, "usageCoreNanoSeconds": %d}, "memory": {"workingSetBytes": 1024, "availableBytes": 3072}}]}]}`,
+			start.Add(s.at).Format(time.RFC3339Nano), s.cpu)
+	}))
+	defer srv.Close()
+
+	// The source is polled by the test, not at the refresh interval.
+	defer func(i time.Duration) { interval = i }(interval)
+	interval = time.Hour
+
+	src, err := newKubeletSource("kubelet:"+srv.URL, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for i := 1; i < len(samples); i++ {
+		if err := src.poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(src.pending) != 2 {
+		t.Fatalf("expected 2 events, the same sample polled again is skipped, got %d", len(src.pending))
+	}
+
+	s := newCaptureStats()
+	if err := s.collect(&sliceSource{events: src.pending}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := s.containers["default/web-1/nginx"]
+	if !ok {
+		t.Fatal("expected the container of the kubelet summary")
+	}
+	if c.CPUPercentage != 150 {
+		t.Fatalf("expected 150%% cpu, got %v", c.CPUPercentage)
+	}
+	// The kubelet lags behind and its clock may be skewed, the samples are
+	// timed when they were received so they do not look stale.
+	if since := time.Since(c.LastSample); since < 0 || since > time.Minute {
+		t.Fatalf("expected the last sample at the time it was received, got %s", c.LastSample)
+	}
+	if c.Name != "nginx" || c.MemoryLimit != 4096 {
+		t.Fatalf("unexpected container %+v", c)
+	}
+}
@@ -1,57 +1,72 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
-	"sync"
 	"syscall"
-	"text/tabwriter"
 	"time"
 
-	units "github.com/docker/go-units"
 	"github.com/genuinetools/magneto/types"
 	"github.com/genuinetools/magneto/version"
 	"github.com/genuinetools/pkg/cli"
-	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	nanoSecondsPerSecond = 1e9
+	// minRedrawInterval is the minimum time between two redraws when
+	// redrawing on every sample, so fast streams do not flood the terminal.
+	minRedrawInterval = 250 * time.Millisecond
 )
 
 var (
-	debug bool
+	debug   bool
+	detail  bool
+	totals  bool
+	format  string
+	listen  string
+	root    string
+	filters stringsFlag
+	groupBy string
+	record  string
+	summary string
+	onEOF   string
+
+	interval time.Duration
+	onSample bool
+
+	rules      ruleFlag
+	ruleEvents string
+
+	staleIntervals int
+	staleExit      bool
+	staleHook      string
+
+	webhooks        stringsFlag
+	webhookTemplate string
+
+	dockerRoot string
+
+	hooks           hookFlag
+	hookTimeout     time.Duration
+	hookConcurrency int
 )
 
 type event struct {
 	Type string      `json:"type"`
 	ID   string      `json:"id"`
 	Data types.Stats `json:"data,omitempty"`
-}
-
-type containerStats struct {
-	CPUPercentage       float64
-	Memory              float64
-	MemoryLimit         float64
-	MemoryPercentage    float64
-	NetworkRx           float64
-	NetworkTx           float64
-	BlockRead           float64
-	BlockWrite          float64
-	PidsCurrent         uint64
-	mu                  sync.RWMutex
-	bufReader           *bufio.Reader
-	clockTicksPerSecond uint64
-	err                 error
+	// Name and Labels are set by the sources that know the containers.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// systemUsage is set by the sources that know the cpu time available to
+	// the container when the sample was taken, used instead of /proc/stat at
+	// the time the event was received. The event is still timed when it was
+	// received, so the stale checks and the captures use the local clock.
+	systemUsage uint64
 }
 
 func main() {
@@ -60,6 +75,15 @@ func main() {
 	p.Name = "magneto"
 	p.Description = "Pipe runc events to a stats TUI (Text User Interface)"
 
+	// Setup the commands.
+	p.Commands = []cli.Command{
+		&assertCommand{},
+		&checkCommand{},
+		&compareCommand{},
+		&recordCommand{},
+		&replayCommand{},
+	}
+
 	// Set the GitCommit and Version.
 	p.GitCommit = version.GITCOMMIT
 	p.Version = version.VERSION
@@ -67,6 +91,29 @@ func main() {
 	// Setup the global flags.
 	p.FlagSet = flag.NewFlagSet("global", flag.ExitOnError)
 	p.FlagSet.BoolVar(&debug, "d", false, "enable debug logging")
+	p.FlagSet.BoolVar(&detail, "detail", false, "show the detailed statistics below the table")
+	p.FlagSet.StringVar(&record, "record", "", "record the events to a capture file while displaying them")
+	p.FlagSet.Var(&rules, "rule", "threshold rule like \"cpu > 90% for 30s\", can be passed multiple times")
+	p.FlagSet.StringVar(&ruleEvents, "rule-events", "", "file to append the rule state changes to as JSON")
+	p.FlagSet.StringVar(&root, "root", "/run/runc", "root directory of the runc container states")
+	p.FlagSet.StringVar(&dockerRoot, "docker-root", "/var/lib/docker", "root directory of docker to read the container names from")
+	p.FlagSet.StringVar(&groupBy, "group-by", "", "group the containers in collapsed rows by label:KEY or regex:PATTERN over the ids, or none, instead of by pod")
+	p.FlagSet.Var(&filters, "filter", "only show the containers whose name or id matches the pattern, or with a label matching KEY=PATTERN, can be passed multiple times")
+	p.FlagSet.DurationVar(&interval, "interval", 5*time.Second, "interval to refresh the display at")
+	p.FlagSet.BoolVar(&onSample, "on-sample", false, "refresh the display as soon as a new sample arrives")
+	p.FlagSet.StringVar(&onEOF, "on-eof", "exit", "what to do when the events stream ends (exit, stale)")
+	p.FlagSet.IntVar(&staleIntervals, "stale", 3, "number of sample intervals without a sample after which a container is stale")
+	p.FlagSet.BoolVar(&staleExit, "stale-exit", false, "exit with an error when a container becomes stale")
+	p.FlagSet.StringVar(&staleHook, "stale-hook", "", "command to run with the shell when a container becomes stale, like a disappear hook")
+	p.FlagSet.StringVar(&summary, "summary", "text", "format of the summary printed on exit (text, json, none)")
+	p.FlagSet.BoolVar(&totals, "totals", false, "show network and block I/O totals instead of rates")
+	p.FlagSet.StringVar(&format, "format", "table", "output format (table, json, none)")
+	p.FlagSet.StringVar(&listen, "listen", "", "address to serve the Prometheus metrics on, like :9100")
+	p.FlagSet.Var(&hooks, "hook", "command to run with the shell on the events as [event,...:]command, with event firing, resolved, oom, appear or disappear, can be passed multiple times")
+	p.FlagSet.DurationVar(&hookTimeout, "hook-timeout", 30*time.Second, "time after which a hook is killed")
+	p.FlagSet.IntVar(&hookConcurrency, "hook-concurrency", 4, "maximum number of hooks running at the same time")
+	p.FlagSet.Var(&webhooks, "webhook", "URL to post the alerts to as [preset=]URL, with preset json, slack or alertmanager, can be passed multiple times")
+	p.FlagSet.StringVar(&webhookTemplate, "webhook-template", "", "Go template file for the payload of the webhooks without a preset")
 
 	// Set the before function.
 	p.Before = func(ctx context.Context) error {
@@ -75,240 +122,151 @@ func main() {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
 
-		return nil
-	}
+		if format != "table" && format != "json" && format != "none" {
+			return fmt.Errorf("unknown output format %q, must be table, json or none", format)
+		}
 
-	// Set the main program action.
-	p.Action = func(ctx context.Context, args []string) error {
-		// On ^C, or SIGTERM handle exit.
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		signal.Notify(c, syscall.SIGTERM)
-		go func() {
-			for sig := range c {
-				logrus.Infof("Received %s, exiting.", sig.String())
-				os.Exit(0)
-			}
-		}()
-		// create the writer
-		w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
-		printHeader := func() {
-			fmt.Fprint(os.Stdout, "\033[2J")
-			fmt.Fprint(os.Stdout, "\033[H")
-			io.WriteString(w, "CPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+		if summary != "text" && summary != "json" && summary != "none" {
+			return fmt.Errorf("unknown summary format %q, must be text, json or none", summary)
 		}
 
-		// collect the stats
-		s := &containerStats{
-			clockTicksPerSecond: uint64(system.GetClockTicks()),
-			bufReader:           bufio.NewReaderSize(nil, 128),
+		if onEOF != "exit" && onEOF != "stale" {
+			return fmt.Errorf("unknown end of stream behavior %q, must be exit or stale", onEOF)
 		}
 
-		go s.collect()
+		if interval <= 0 {
+			return fmt.Errorf("the refresh interval must be greater than 0")
+		}
 
-		for range time.Tick(5 * time.Second) {
-			printHeader()
-			if err := s.Display(w); err != nil {
-				logrus.Error(err)
+		if staleIntervals < 1 {
+			return fmt.Errorf("the number of sample intervals for stale containers must be at least 1")
+		}
+
+		if hookTimeout <= 0 {
+			return fmt.Errorf("the hook timeout must be greater than 0")
+		}
+
+		if hookConcurrency < 1 {
+			return fmt.Errorf("the number of concurrent hooks must be at least 1")
+		}
+
+		// The stale hook is a hook on the disappear event.
+		if staleHook != "" {
+			hooks = append(hooks, &hook{command: staleHook, events: map[string]bool{hookDisappear: true}})
+		}
+
+		if groupBy != "" {
+			g, err := parseGrouping(groupBy)
+			if err != nil {
+				return err
 			}
-			w.Flush()
+			containerGrouping = g
 		}
 
 		return nil
 	}
 
-	// Run our program.
-	p.Run()
-}
+	// Set the main program action.
+	p.Action = func(ctx context.Context, args []string) error {
+		s := newStats()
 
-func (s *containerStats) collect() {
-	var (
-		previousCPU    uint64
-		previousSystem uint64
-		dec            = json.NewDecoder(os.Stdin)
-		u              = make(chan error, 1)
-	)
-
-	go func() {
-		for {
-			var (
-				e                      event
-				memPercent, cpuPercent float64
-				blkRead, blkWrite      uint64 // Only used on Linux
-				mem, memLimit          float64
-				netRx, netTx           float64
-				pidsCurrent            uint64
-			)
-
-			if err := dec.Decode(&e); err != nil {
-				u <- err
-				time.Sleep(100 * time.Millisecond)
-				continue
+		if ruleEvents != "" {
+			if err := s.writeRuleEvents(ruleEvents); err != nil {
+				return err
 			}
+		}
+		if err := s.addWebhooks(webhooks, webhookTemplate); err != nil {
+			return err
+		}
+		s.addHooks(hooks, hookTimeout, hookConcurrency)
 
-			if e.Type != "stats" {
-				// do nothing since there are no other events yet
-				continue
+		if record != "" {
+			recorder, err := newCaptureWriter(record, s.systemCPU.ClockTicks)
+			if err != nil {
+				return err
 			}
-			v := e.Data
+			s.recorder = recorder
+		}
 
-			systemUsage, err := s.getSystemCPUUsage()
+		inputs := args
+		if len(inputs) == 0 {
+			inputs = []string{"-"}
+		}
+		pl := &pipeline{s: s}
+		for _, input := range inputs {
+			src, err := openSource(input)
 			if err != nil {
-				u <- fmt.Errorf("collecting system cpu usage failed: %v", err)
-				continue
+				pl.close()
+				return fmt.Errorf("opening %s failed: %v", input, err)
 			}
-
-			cpuPercent = calculateCPUPercent(previousCPU, previousSystem, systemUsage, v)
-			previousCPU = v.CPU.Usage.Total
-			previousSystem = systemUsage
-
-			blkRead, blkWrite = calculateBlockIO(v.Blkio)
-
-			mem = calculateMemUsageNoCache(v.Memory)
-			memLimit = float64(v.Memory.Usage.Limit)
-			memPercent = calculateMemPercentNoCache(s.MemoryLimit, s.Memory)
-
-			pidsCurrent = v.Pids.Current
-
-			// set the stats
-			s.mu.Lock()
-			s.CPUPercentage = cpuPercent
-			s.BlockRead = float64(blkRead)
-			s.BlockWrite = float64(blkWrite)
-			s.Memory = mem
-			s.MemoryLimit = memLimit
-			s.MemoryPercentage = memPercent
-			s.NetworkRx = netRx
-			s.NetworkTx = netTx
-			s.PidsCurrent = pidsCurrent
-			s.mu.Unlock()
-
-			u <- nil
+			pl.sources = append(pl.sources, src)
 		}
-	}()
+		s.showSources = len(pl.sources) > 1
 
-	for {
-		err := <-u
-		if err != nil {
-			s.setError(err)
+		if sink := newDisplaySink(); sink != nil {
+			pl.sinks = append(pl.sinks, sink)
+			if t, ok := sink.(*tableSink); ok {
+				t.readKeys(keysTerminal(inputs))
+			}
+		}
+		if listen != "" {
+			sink, err := newPrometheusSink(listen, s)
+			if err != nil {
+				return err
+			}
+			pl.sinks = append(pl.sinks, sink)
 		}
-		continue
-
-	}
-}
 
-func (s *containerStats) Display(w io.Writer) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+		// On ^C, or SIGTERM handle exit.
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		signal.Notify(c, syscall.SIGTERM)
+		go func() {
+			for sig := range c {
+				logrus.Infof("Received %s, exiting.", sig.String())
+				pl.close()
+				shutdown(s)
+				os.Exit(0)
+			}
+		}()
 
-	// check the error here
-	if s.err != nil {
-		return s.err
+		err := pl.run()
+		shutdown(s)
+		return err
 	}
 
-	fmt.Fprintf(w, "%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
-		s.CPUPercentage,
-		units.BytesSize(s.Memory), units.BytesSize(s.MemoryLimit),
-		s.MemoryPercentage,
-		units.HumanSizeWithPrecision(s.NetworkRx, 3), units.HumanSizeWithPrecision(s.NetworkTx, 3),
-		units.HumanSizeWithPrecision(s.BlockRead, 3), units.HumanSizeWithPrecision(s.BlockWrite, 3),
-		s.PidsCurrent)
-	return nil
-}
-
-// setError sets container statistics error
-func (s *containerStats) setError(err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.err = err
-}
-
-func calculateCPUPercent(previousCPU, previousSystem, systemUsage uint64, v types.Stats) float64 {
-	var (
-		cpuPercent = 0.0
-		// calculate the change for the cpu usage of the container in between readings
-		cpuDelta = float64(v.CPU.Usage.Total) - float64(previousCPU)
-		// calculate the change for the entire system between readings
-		systemDelta = float64(systemUsage) - float64(previousSystem)
-	)
-
-	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuPercent = (cpuDelta / systemDelta) * float64(len(v.CPU.Usage.Percpu)) * 100.0
-	}
-	return cpuPercent
+	// Run our program.
+	p.Run()
 }
 
-func calculateBlockIO(blkio types.Blkio) (uint64, uint64) {
-	var blkRead, blkWrite uint64
-	for _, bioEntry := range blkio.IoServiceBytesRecursive {
-		switch strings.ToLower(bioEntry.Op) {
-		case "read":
-			blkRead = blkRead + bioEntry.Value
-		case "write":
-			blkWrite = blkWrite + bioEntry.Value
+// keysTerminal returns the terminal to read the keys from: stdin, unless the
+// events are read from it, then the controlling terminal. It returns nil if
+// there is none.
+func keysTerminal(inputs []string) *os.File {
+	for _, input := range inputs {
+		if input == "-" {
+			tty, err := os.Open("/dev/tty")
+			if err != nil {
+				return nil
+			}
+			return tty
 		}
 	}
-	return blkRead, blkWrite
-}
-
-// calculateMemUsageNoCache calculate memory usage of the container.
-// Page cache is intentionally excluded to avoid misinterpretation of the output.
-func calculateMemUsageNoCache(mem types.Memory) float64 {
-	return float64(mem.Usage.Usage - mem.Cache)
+	return os.Stdin
 }
 
-func calculateMemPercentNoCache(limit float64, usedNoCache float64) float64 {
-	// MemoryStats.Limit will never be 0 unless the container is not running and we haven't
-	// got any data from cgroup
-	if limit != 0 {
-		return usedNoCache / limit * 100.0
-	}
-	return 0
-}
-
-// getSystemCPUUsage returns the host system's cpu usage in
-// nanoseconds. An error is returned if the format of the underlying
-// file does not match.
-//
-// Uses /proc/stat defined by POSIX. Looks for the cpu
-// statistics line and then sums up the first seven fields
-// provided. See `man 5 proc` for details on specific field
-// information.
-func (s *containerStats) getSystemCPUUsage() (uint64, error) {
-	var line string
-	f, err := os.Open("/proc/stat")
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		s.bufReader.Reset(nil)
-		f.Close()
-	}()
-	s.bufReader.Reset(f)
-	err = nil
-	for err == nil {
-		line, err = s.bufReader.ReadString('\n')
-		if err != nil {
-			break
-		}
-		parts := strings.Fields(line)
-		switch parts[0] {
-		case "cpu":
-			if len(parts) < 8 {
-				return 0, fmt.Errorf("invalid number of cpu fields")
-			}
-			var totalClockTicks uint64
-			for _, i := range parts[1:8] {
-				v, err := strconv.ParseUint(i, 10, 64)
-				if err != nil {
-					return 0, fmt.Errorf("unable to convert value %s to int: %s", i, err)
-				}
-				totalClockTicks += v
+// shutdown closes the capture file and the webhooks and prints the summary
+// before exiting. Only the first call does, later calls wait for it.
+func shutdown(s *stats) {
+	s.shutdownOnce.Do(func() {
+		if s.recorder != nil {
+			if err := s.recorder.Close(); err != nil {
+				logrus.Error(err)
 			}
-			return (totalClockTicks * nanoSecondsPerSecond) /
-				s.clockTicksPerSecond, nil
 		}
-	}
-
-	return 0, fmt.Errorf("invalid stat format. Error trying to parse the '/proc/stat' file")
+		for _, c := range s.closers {
+			c()
+		}
+		printSummary(s)
+	})
 }
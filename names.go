@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// The annotations containerd sets on the containers of a pod.
+const (
+	annotationCRISandboxName      = "io.kubernetes.cri.sandbox-name"
+	annotationCRISandboxNamespace = "io.kubernetes.cri.sandbox-namespace"
+	annotationCRISandboxUID       = "io.kubernetes.cri.sandbox-uid"
+	annotationCRIContainerName    = "io.kubernetes.cri.container-name"
+)
+
+// criLabels maps the containerd annotations to the labels the kubelet sets
+// on the containers, so the containers are grouped by pod either way.
+var criLabels = map[string]string{
+	annotationCRISandboxName:      labelPodName,
+	annotationCRISandboxNamespace: labelPodNamespace,
+	annotationCRISandboxUID:       labelPodUID,
+	annotationCRIContainerName:    labelContainer,
+}
+
+// nameLabels are the labels holding the name of a container, in order of
+// preference.
+var nameLabels = []string{
+	labelContainer,
+	"nerdctl/name",
+	"io.podman.annotations.name",
+}
+
+// resolveName returns the name and the labels of the container from the
+// labels of its libcontainer config, which has the bundle path and the
+// annotations, the config.json of its OCI bundle and its docker
+// config.v2.json. The name is empty if none of them has one.
+func resolveName(id string, state *types.State) (string, map[string]string) {
+	labels := map[string]string{}
+	if state != nil {
+		for _, l := range state.Config.Labels {
+			if kv := strings.SplitN(l, "=", 2); len(kv) == 2 {
+				labels[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	if bundle := labels["bundle"]; bundle != "" {
+		var spec struct {
+			Annotations map[string]string `json:"annotations"`
+		}
+		if err := readJSON(filepath.Join(bundle, "config.json"), &spec); err == nil {
+			for k, v := range spec.Annotations {
+				if _, ok := labels[k]; !ok {
+					labels[k] = v
+				}
+			}
+		}
+	}
+
+	var name string
+	var docker struct {
+		Name   string `json:"Name"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := readJSON(filepath.Join(dockerRoot, "containers", id, "config.v2.json"), &docker); err == nil {
+		name = strings.TrimPrefix(docker.Name, "/")
+		for k, v := range docker.Config.Labels {
+			labels[k] = v
+		}
+	}
+
+	for annotation, label := range criLabels {
+		if v, ok := labels[annotation]; ok {
+			if _, ok := labels[label]; !ok {
+				labels[label] = v
+			}
+		}
+	}
+
+	for _, l := range nameLabels {
+		if name != "" {
+			break
+		}
+		name = labels[l]
+	}
+	return name, labels
+}
+
+// readJSON decodes the JSON file into v.
+func readJSON(file string, v interface{}) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// displayName returns the name of the container, its id if it has none.
+func (c *containerStats) displayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.ID
+}
+
+// matchesFilters returns whether the container matches one of the filters,
+// true if there are none. A filter is a pattern matched against the name and
+// the id of the container, or KEY=PATTERN matched against a label.
+func (c *containerStats) matchesFilters(filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			if v, ok := c.Labels[kv[0]]; ok {
+				if matched, _ := path.Match(kv[1], v); matched {
+					return true
+				}
+			}
+			continue
+		}
+		for _, s := range []string{c.Name, c.ID} {
+			if matched, _ := path.Match(f, s); matched && s != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
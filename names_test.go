@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func TestResolveName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		stateLabels []string
+		// annotations are the annotations of the config.json of the
+		// bundle, nil for no bundle.
+		annotations map[string]string
+		// docker is the config.v2.json of docker, empty for none.
+		docker string
+
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:        "state labels",
+			stateLabels: []string{"nerdctl/name=web", "tier=front", "invalid"},
+			wantName:    "web",
+			wantLabels:  map[string]string{"nerdctl/name": "web", "tier": "front"},
+		},
+		{
+			name:        "bundle annotations",
+			stateLabels: []string{"tier=front"},
+			annotations: map[string]string{
+				"tier":                        "back",
+				annotationCRISandboxName:      "web-7d4b9",
+				annotationCRISandboxNamespace: "shop",
+				annotationCRISandboxUID:       "1234",
+				annotationCRIContainerName:    "nginx",
+			},
+			wantName: "nginx",
+			wantLabels: map[string]string{
+				// The state labels are kept over the annotations.
+				"tier":            "front",
+				labelPodName:      "web-7d4b9",
+				labelPodNamespace: "shop",
+				labelPodUID:       "1234",
+				labelContainer:    "nginx",
+			},
+		},
+		{
+			name:        "kubelet labels over cri annotations",
+			stateLabels: []string{labelContainer + "=app", labelPodName + "=web"},
+			annotations: map[string]string{
+				annotationCRISandboxName:   "other",
+				annotationCRIContainerName: "nginx",
+			},
+			wantName:   "app",
+			wantLabels: map[string]string{labelContainer: "app", labelPodName: "web"},
+		},
+		{
+			name:        "docker config",
+			stateLabels: []string{"tier=front", "nerdctl/name=web"},
+			annotations: map[string]string{"owner": "ops"},
+			docker:      `{"Name": "/db", "Config": {"Labels": {"tier": "data"}}}`,
+			// The docker name and labels are taken over the others.
+			wantName:   "db",
+			wantLabels: map[string]string{"tier": "data", "owner": "ops", "nerdctl/name": "web"},
+		},
+		{
+			name:       "docker labels",
+			docker:     `{"Config": {"Labels": {"io.podman.annotations.name": "cache"}}}`,
+			wantName:   "cache",
+			wantLabels: map[string]string{"io.podman.annotations.name": "cache"},
+		},
+		{
+			name:        "name labels in order",
+			stateLabels: []string{"io.podman.annotations.name=cache", "nerdctl/name=web"},
+			wantName:    "web",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "magneto-names")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			defer func(d string) { dockerRoot = d }(dockerRoot)
+			dockerRoot = filepath.Join(dir, "docker")
+
+			state := &types.State{}
+			state.Config.Labels = tc.stateLabels
+			if tc.annotations != nil {
+				bundle := filepath.Join(dir, "bundle")
+				if err := os.MkdirAll(bundle, 0755); err != nil {
+					t.Fatal(err)
+				}
+				b, err := json.Marshal(map[string]interface{}{"annotations": tc.annotations})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), b, 0644); err != nil {
+					t.Fatal(err)
+				}
+				state.Config.Labels = append(state.Config.Labels, "bundle="+bundle)
+			}
+			if tc.docker != "" {
+				config := filepath.Join(dockerRoot, "containers", "abc", "config.v2.json")
+				if err := os.MkdirAll(filepath.Dir(config), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(config, []byte(tc.docker), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			name, labels := resolveName("abc", state)
+			if name != tc.wantName {
+				t.Fatalf("expected name %q, got %q", tc.wantName, name)
+			}
+			for k, v := range tc.wantLabels {
+				if labels[k] != v {
+					t.Fatalf("expected label %s=%q, got %q in %v", k, v, labels[k], labels)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveNameWithoutState(t *testing.T) {
+	defer func(d string) { dockerRoot = d }(dockerRoot)
+	dockerRoot = "/nonexistent"
+
+	name, labels := resolveName("abc", nil)
+	if name != "" || len(labels) != 0 {
+		t.Fatalf("expected no name and labels, got %q and %v", name, labels)
+	}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	c := &containerStats{
+		ID:   "1a2b3c4d",
+		Name: "web-1",
+		Labels: map[string]string{
+			labelPodNamespace: "shop",
+			"tier":            "front",
+			"empty":           "",
+		},
+	}
+	unnamed := &containerStats{ID: "5e6f7a8b"}
+
+	testCases := []struct {
+		name      string
+		filters   []string
+		container *containerStats
+		want      bool
+	}{
+		{name: "no filters", want: true},
+		{name: "name", filters: []string{"web-1"}, want: true},
+		{name: "name pattern", filters: []string{"web-*"}, want: true},
+		{name: "id pattern", filters: []string{"1a2b*"}, want: true},
+		{name: "no match", filters: []string{"db-*"}},
+		{name: "one of the filters", filters: []string{"db-*", "web-?"}, want: true},
+		{name: "label", filters: []string{"tier=front"}, want: true},
+		{name: "label pattern", filters: []string{labelPodNamespace + "=sh*"}, want: true},
+		{name: "label mismatch", filters: []string{"tier=back"}},
+		{name: "missing label", filters: []string{"app=*"}},
+		{name: "empty label", filters: []string{"empty="}, want: true},
+		{name: "label filter not matched against the name", filters: []string{"web-1=*"}},
+		{name: "invalid pattern", filters: []string{"web-["}},
+		{name: "empty name", filters: []string{"*"}, container: unnamed, want: true},
+		{name: "empty name does not match", filters: []string{""}, container: unnamed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			container := tc.container
+			if container == nil {
+				container = c
+			}
+			if got := container.matchesFilters(tc.filters); got != tc.want {
+				t.Fatalf("expected %t for %q, got %t", tc.want, tc.filters, got)
+			}
+		})
+	}
+}
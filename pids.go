@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// pidsWarnPercentage is the percentage of the pids limit after which the
+	// pids are highlighted as a warning.
+	pidsWarnPercentage = 80.0
+	// pidsCritPercentage is the percentage of the pids limit after which the
+	// pids are highlighted as critical.
+	pidsCritPercentage = 95.0
+
+	// pidsTrendWindow is how far back samples are kept to estimate the trend
+	// of the number of pids.
+	pidsTrendWindow = 5 * time.Minute
+
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+)
+
+type pidsSample struct {
+	current uint64
+	read    time.Time
+}
+
+// pidsTracker keeps the recent pids samples of a container to estimate how
+// long it will take to reach its pids limit.
+type pidsTracker struct {
+	samples []pidsSample
+}
+
+// calculateTimeToLimit adds the sample read at now and returns the estimated
+// time until the pids limit is reached from the least squares trend of the
+// recent samples. It returns 0 if there is no limit or the number of pids is
+// not growing.
+func (t *pidsTracker) calculateTimeToLimit(current, limit uint64, now time.Time) time.Duration {
+	// Drop the samples outside of the trend window.
+	i := 0
+	for i < len(t.samples) && now.Sub(t.samples[i].read) > pidsTrendWindow {
+		i++
+	}
+	t.samples = append(t.samples[i:], pidsSample{current: current, read: now})
+
+	if limit == 0 || current >= limit || len(t.samples) < 2 {
+		return 0
+	}
+
+	// Fit current = slope * seconds + intercept over the samples.
+	var (
+		n                  = float64(len(t.samples))
+		sumX, sumY         float64
+		sumXY, sumXSquared float64
+	)
+	for _, sample := range t.samples {
+		x := sample.read.Sub(t.samples[0].read).Seconds()
+		y := float64(sample.current)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXSquared += x * x
+	}
+	denominator := n*sumXSquared - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if slope <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(limit-current) / slope * float64(time.Second))
+}
+
+// formatPids formats the pids column as "current / limit (pct%)" and
+// highlights it as the container approaches its limit.
+func formatPids(current, limit uint64, percentage float64, timeToLimit time.Duration) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d", current)
+	}
+
+	pids := fmt.Sprintf("%d / %d (%.2f%%)", current, limit, percentage)
+	if timeToLimit > 0 {
+		eta := timeToLimit.Round(time.Second)
+		if eta < time.Second {
+			eta = time.Second
+		}
+		pids = fmt.Sprintf("%s limit in %s", pids, eta)
+	}
+
+	switch {
+	case percentage >= pidsCritPercentage:
+		return colorRed + pids + colorReset
+	case percentage >= pidsWarnPercentage:
+		return colorYellow + pids + colorReset
+	}
+	return pids
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateTimeToLimit(t *testing.T) {
+	start := time.Unix(1000, 0)
+	type sample struct {
+		current uint64
+		at      time.Duration
+	}
+	testCases := []struct {
+		name    string
+		limit   uint64
+		samples []sample
+		want    time.Duration
+	}{
+		{
+			name:    "first sample",
+			limit:   100,
+			samples: []sample{{10, 0}},
+		},
+		{
+			name:    "no limit",
+			samples: []sample{{10, 0}, {20, time.Second}},
+		},
+		{
+			name:    "growing",
+			limit:   100,
+			samples: []sample{{10, 0}, {20, time.Second}, {30, 2 * time.Second}},
+			want:    7 * time.Second,
+		},
+		{
+			name:    "shrinking",
+			limit:   100,
+			samples: []sample{{30, 0}, {20, time.Second}},
+		},
+		{
+			name:    "flat",
+			limit:   100,
+			samples: []sample{{30, 0}, {30, time.Second}},
+		},
+		{
+			name:    "at the limit",
+			limit:   100,
+			samples: []sample{{50, 0}, {100, time.Second}},
+		},
+		{
+			name:  "samples outside of the window are dropped",
+			limit: 100,
+			samples: []sample{
+				// A burst long ago, then a slow growth.
+				{0, 0},
+				{90, time.Second},
+				{90, pidsTrendWindow + 2*time.Second},
+				{91, pidsTrendWindow + 3*time.Second},
+			},
+			// 1 pid per second without the old samples.
+			want: 9 * time.Second,
+		},
+		{
+			name:    "samples at the same time",
+			limit:   100,
+			samples: []sample{{10, time.Second}, {20, time.Second}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := &pidsTracker{}
+			var got time.Duration
+			for _, s := range tc.samples {
+				got = tracker.calculateTimeToLimit(s.current, tc.limit, start.Add(s.at))
+			}
+			if diff := got - tc.want; diff > time.Millisecond || diff < -time.Millisecond {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatPids(t *testing.T) {
+	testCases := []struct {
+		name        string
+		current     uint64
+		limit       uint64
+		percentage  float64
+		timeToLimit time.Duration
+		want        string
+	}{
+		{"no limit", 12, 0, 0, 0, "12"},
+		{"below the warning", 10, 100, 10, 0, "10 / 100 (10.00%)"},
+		{"time to limit", 10, 100, 10, 90500 * time.Millisecond, "10 / 100 (10.00%) limit in 1m31s"},
+		{"time to limit below a second", 10, 100, 10, time.Millisecond, "10 / 100 (10.00%) limit in 1s"},
+		{"warning", 80, 100, 80, 0, colorYellow + "80 / 100 (80.00%)" + colorReset},
+		{"critical", 95, 100, 95, 0, colorRed + "95 / 100 (95.00%)" + colorReset},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatPids(tc.current, tc.limit, tc.percentage, tc.timeToLimit); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
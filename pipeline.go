@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pipeline collects the events of the sources into the statistics and
+// writes them to the sinks.
+type pipeline struct {
+	s       *stats
+	sources []Source
+	sinks   []Sink
+
+	// mu keeps the sinks from being updated while or after they are closed.
+	mu        sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// update writes the statistics to every sink.
+func (p *pipeline) update() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	for _, sink := range p.sinks {
+		if err := sink.Update(p.s); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// close stops the sources, waits for the events being processed and closes
+// the sinks with the final statistics. Only the first call does, so it can be
+// called both on a signal and when run returns.
+func (p *pipeline) close() {
+	p.closeOnce.Do(func() {
+		for _, src := range p.sources {
+			src.Close()
+		}
+		p.s.stop()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.closed = true
+		for _, sink := range p.sinks {
+			if err := sink.Close(p.s); err != nil {
+				logrus.Error(err)
+			}
+		}
+	})
+}
+
+// run collects the sources and updates the sinks at the refresh interval,
+// or as soon as a sample arrives if enabled, until the sources ended or a
+// container became stale when exiting on stale containers. The sources are
+// read concurrently, a source that failed does not stop the others.
+func (p *pipeline) run() error {
+	s := p.s
+
+	// collect the stats
+	done := make(chan error, len(p.sources))
+	for _, src := range p.sources {
+		go func(src Source) {
+			err := s.collect(src)
+			if err != nil {
+				err = fmt.Errorf("reading events from %s failed: %v", src.Name(), err)
+			}
+			done <- err
+		}(src)
+	}
+	running := len(p.sources)
+
+	// watch for containers that stopped receiving samples
+	staleErr := make(chan error, 1)
+	go s.watchStale(staleErr)
+
+	// redraw on the samples if enabled, limiting how often
+	var (
+		sampled    <-chan struct{}
+		throttle   <-chan time.Time
+		lastRedraw time.Time
+	)
+	if onSample {
+		sampled = s.updated
+	}
+	redraw := func() {
+		p.update()
+		lastRedraw = time.Now()
+		throttle = nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			redraw()
+		case <-sampled:
+			if since := time.Since(lastRedraw); since < minRedrawInterval {
+				if throttle == nil {
+					throttle = time.After(minRedrawInterval - since)
+				}
+				continue
+			}
+			redraw()
+		case <-throttle:
+			redraw()
+		case err := <-staleErr:
+			p.update()
+			p.close()
+			return err
+		case err := <-done:
+			if err != nil {
+				s.addSourceError(err)
+			}
+			if running--; running > 0 {
+				continue
+			}
+			s.setEnded()
+			p.update()
+
+			if onEOF == "exit" {
+				p.close()
+				return nil
+			}
+			// Keep showing the last values, marked as stale.
+			done = nil
+		}
+	}
+}
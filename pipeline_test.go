@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chanSource returns the events sent on its channel, then its error or
+// io.EOF once the channel is closed.
+type chanSource struct {
+	name   string
+	events chan event
+	err    error
+}
+
+func (s *chanSource) Name() string      { return s.name }
+func (s *chanSource) Malformed() uint64 { return 0 }
+func (s *chanSource) Close() error      { return nil }
+
+func (s *chanSource) Read() (event, error) {
+	e, ok := <-s.events
+	if !ok {
+		if s.err != nil {
+			return event{}, s.err
+		}
+		return event{}, io.EOF
+	}
+	return e, nil
+}
+
+// testSink counts the updates and the closes of the pipeline.
+type testSink struct {
+	mu      sync.Mutex
+	updates int
+	closes  int
+	// ended receives a value when an update happens after the stream ended.
+	ended chan struct{}
+}
+
+func newTestSink() *testSink {
+	return &testSink{ended: make(chan struct{}, 1)}
+}
+
+func (t *testSink) Update(s *stats) error {
+	t.mu.Lock()
+	t.updates++
+	t.mu.Unlock()
+
+	s.mu.RLock()
+	ended := !s.ended.IsZero()
+	s.mu.RUnlock()
+	if ended {
+		select {
+		case t.ended <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (t *testSink) Close(s *stats) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closes++
+	return nil
+}
+
+func (t *testSink) updated() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.updates
+}
+
+func (t *testSink) closed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closes
+}
+
+// containerIDs returns the sorted ids of the containers of the statistics.
+func containerIDs(s *stats) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.containers))
+	for id := range s.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// runPipeline runs the pipeline in the background, returning the channel
+// receiving its result.
+func runPipeline(p *pipeline) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- p.run() }()
+	return done
+}
+
+func TestPipelineMalformed(t *testing.T) {
+	defer func(i time.Duration, e string) { interval, onEOF = i, e }(interval, onEOF)
+	interval, onEOF = time.Hour, "exit"
+
+	input := strings.Join([]string{
+		`{"type":"stats","id":"abc","data":{"cpu":{"usage":{"total":1}}}}`,
+		`{"type":"stats","id":`,
+		`not json`,
+		``,
+		`{"type":"stats","id":"def","data":{"cpu":{"usage":{"total":1}}}}`,
+		`{"type":"stats","id":"abc","data":{"cpu":{"usage":{"total":2}}}}`,
+	}, "\n")
+	sink := newTestSink()
+	p := &pipeline{
+		s:       newCaptureStats(),
+		sources: []Source{newReaderSource("stdin", strings.NewReader(input))},
+		sinks:   []Sink{sink},
+	}
+
+	select {
+	case err := <-runPipeline(p):
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pipeline to exit at the end of the stream")
+	}
+
+	if ids := containerIDs(p.s); !reflect.DeepEqual(ids, []string{"abc", "def"}) {
+		t.Fatalf("expected the containers around the malformed lines, got %v", ids)
+	}
+	if p.s.malformed != 2 {
+		t.Fatalf("expected 2 malformed events, got %d", p.s.malformed)
+	}
+	if summary := p.s.Summary(); len(summary) != 2 || summary[0].ID != "abc" || summary[0].Samples != 2 {
+		t.Fatalf("expected 2 samples of abc, got %+v", summary)
+	}
+	if sink.closed() != 1 {
+		t.Fatalf("expected the sink to be closed once, got %d", sink.closed())
+	}
+}
+
+func TestPipelineOnEOF(t *testing.T) {
+	defer func(i time.Duration, e string, o bool) { interval, onEOF, onSample = i, e, o }(interval, onEOF, onSample)
+	interval = time.Hour
+
+	for _, mode := range []string{"exit", "stale"} {
+		t.Run(mode, func(t *testing.T) {
+			onEOF = mode
+			// The display is refreshed on the samples, so the pipeline that
+			// keeps running can be waited for.
+			onSample = mode == "stale"
+
+			src := &chanSource{name: "a", events: make(chan event, 1)}
+			src.events <- event{Type: "stats", ID: "abc"}
+			close(src.events)
+			sink := newTestSink()
+			p := &pipeline{s: newCaptureStats(), sources: []Source{src}, sinks: []Sink{sink}}
+			done := runPipeline(p)
+
+			select {
+			case <-sink.ended:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the sinks to be updated at the end of the stream")
+			}
+
+			if mode == "exit" {
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("expected the pipeline to exit at the end of the stream")
+				}
+				if sink.closed() != 1 {
+					t.Fatalf("expected the sink to be closed once, got %d", sink.closed())
+				}
+				return
+			}
+
+			// The last values are kept until the pipeline is closed.
+			select {
+			case err := <-done:
+				t.Fatalf("expected the pipeline to keep running, got %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if sink.closed() != 0 {
+				t.Fatal("expected the sink not to be closed")
+			}
+			if ids := containerIDs(p.s); !reflect.DeepEqual(ids, []string{"abc"}) {
+				t.Fatalf("expected the last values of abc, got %v", ids)
+			}
+
+			// The display keeps being refreshed.
+			before := sink.updated()
+			p.s.updated <- struct{}{}
+			deadline := time.Now().Add(5 * time.Second)
+			for sink.updated() == before {
+				if time.Now().After(deadline) {
+					t.Fatal("expected the sinks to keep being updated")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			p.close()
+			if sink.closed() != 1 {
+				t.Fatalf("expected the sink to be closed once, got %d", sink.closed())
+			}
+		})
+	}
+}
+
+func TestPipelineSourceFails(t *testing.T) {
+	defer func(i time.Duration, e string) { interval, onEOF = i, e }(interval, onEOF)
+	interval, onEOF = time.Hour, "exit"
+
+	failing := &chanSource{name: "a", events: make(chan event, 1), err: errors.New("broken pipe")}
+	running := &chanSource{name: "b", events: make(chan event)}
+	sink := newTestSink()
+	p := &pipeline{s: newCaptureStats(), sources: []Source{failing, running}, sinks: []Sink{sink}}
+	done := runPipeline(p)
+
+	failing.events <- event{Type: "stats", ID: "abc"}
+	close(failing.events)
+
+	// The other source keeps being read once the first one failed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.s.mu.RLock()
+		failed := len(p.s.sourceErrs)
+		p.s.mu.RUnlock()
+		if failed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the source error to be recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected the pipeline to keep running, got %v", err)
+	default:
+	}
+	running.events <- event{Type: "stats", ID: "def"}
+	close(running.events)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pipeline to exit once all the sources ended")
+	}
+
+	if ids := containerIDs(p.s); !reflect.DeepEqual(ids, []string{"abc", "def"}) {
+		t.Fatalf("expected the containers of both sources, got %v", ids)
+	}
+	if len(p.s.sourceErrs) != 1 || p.s.sourceErrs[0].Error() != "reading events from a failed: broken pipe" {
+		t.Fatalf("expected the error of the failed source, got %v", p.s.sourceErrs)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/genuinetools/magneto/types"
+)
+
+// podmanStats is a sample of podman stats --format json. The values are
+// formatted for humans, so the sizes lose some precision.
+type podmanStats struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	CPUTime  string `json:"cpu_time"`
+	MemUsage string `json:"mem_usage"`
+	NetIO    string `json:"net_io"`
+	BlockIO  string `json:"block_io"`
+	PIDs     string `json:"pids"`
+}
+
+// event converts the sample to a runc stats event.
+func (p *podmanStats) event() (event, error) {
+	var v types.Stats
+
+	if p.ID == "" {
+		return event{}, fmt.Errorf("missing container id")
+	}
+
+	cpu, err := time.ParseDuration(p.CPUTime)
+	if err != nil {
+		return event{}, fmt.Errorf("parsing cpu time %q failed: %v", p.CPUTime, err)
+	}
+	v.CPU.Usage.Total = uint64(cpu)
+	// podman runs on this host, the cpu percentage is scaled by its cpus.
+	v.CPU.Usage.Percpu = make([]uint64, runtime.NumCPU())
+
+	usage, limit, err := parsePodmanSizes(p.MemUsage)
+	if err != nil {
+		return event{}, fmt.Errorf("parsing memory usage %q failed: %v", p.MemUsage, err)
+	}
+	v.Memory.Usage = types.MemoryEntry{Usage: usage, Limit: limit}
+
+	rx, tx, err := parsePodmanSizes(p.NetIO)
+	if err != nil {
+		return event{}, fmt.Errorf("parsing network I/O %q failed: %v", p.NetIO, err)
+	}
+	v.NetworkInterfaces = []*types.NetworkInterface{{RxBytes: rx, TxBytes: tx}}
+
+	read, write, err := parsePodmanSizes(p.BlockIO)
+	if err != nil {
+		return event{}, fmt.Errorf("parsing block I/O %q failed: %v", p.BlockIO, err)
+	}
+	v.Blkio.IoServiceBytesRecursive = []types.BlkioEntry{
+		{Op: "Read", Value: read},
+		{Op: "Write", Value: write},
+	}
+
+	if p.PIDs != "" && p.PIDs != "--" {
+		pids, err := strconv.ParseUint(p.PIDs, 10, 64)
+		if err != nil {
+			return event{}, fmt.Errorf("parsing pids %q failed: %v", p.PIDs, err)
+		}
+		v.Pids.Current = pids
+	}
+
+	return event{Type: "stats", ID: p.ID, Name: p.Name, Data: v}, nil
+}
+
+// parsePodmanSizes parses two sizes formatted like "1.5MB / 2GB". Values that
+// are not available, shown as "--", are 0.
+func parsePodmanSizes(s string) (uint64, uint64, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected two sizes separated by /")
+	}
+
+	var sizes [2]uint64
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "--" {
+			continue
+		}
+		n, err := units.FromHumanSize(part)
+		if err != nil {
+			return 0, 0, err
+		}
+		sizes[i] = uint64(n)
+	}
+	return sizes[0], sizes[1], nil
+}
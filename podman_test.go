@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	mstats "github.com/genuinetools/magneto/stats"
+)
+
+func TestPodmanStatsEvent(t *testing.T) {
+	testCases := []struct {
+		name   string
+		sample string
+
+		cpu, memory, limit, rx, tx, read, write, pids uint64
+		expectedErr                                   string
+	}{
+		{
+			name:   "running",
+			sample: `{"id":"e4b1c2d3a5f6","name":"web","cpu_time":"1.520314s","cpu_percent":"0.12%","avg_cpu":"0.10%","mem_usage":"2.404MB / 16GB","mem_percent":"0.03%","net_io":"5.338kB / 648B","block_io":"2.003MB / 4.096kB","pids":"3"}`,
+			cpu:    1520314000, memory: 2404000, limit: 16000000000, rx: 5338, tx: 648, read: 2003000, write: 4096, pids: 3,
+		},
+		{
+			name:   "not available",
+			sample: `{"id":"e4b1c2d3a5f6","name":"web","cpu_time":"0s","cpu_percent":"--","avg_cpu":"--","mem_usage":"-- / --","mem_percent":"--","net_io":"-- / --","block_io":"-- / --","pids":"--"}`,
+		},
+		{
+			name:        "missing id",
+			sample:      `{"name":"web","cpu_time":"0s","mem_usage":"-- / --","net_io":"-- / --","block_io":"-- / --"}`,
+			expectedErr: "missing container id",
+		},
+		{
+			name:        "invalid cpu time",
+			sample:      `{"id":"e4b1c2d3a5f6","cpu_time":"1.5","mem_usage":"-- / --","net_io":"-- / --","block_io":"-- / --"}`,
+			expectedErr: `parsing cpu time "1.5" failed: time: missing unit in duration "1.5"`,
+		},
+		{
+			name:        "invalid memory usage",
+			sample:      `{"id":"e4b1c2d3a5f6","cpu_time":"1s","mem_usage":"2.404MB","net_io":"-- / --","block_io":"-- / --"}`,
+			expectedErr: `parsing memory usage "2.404MB" failed: expected two sizes separated by /`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var p podmanStats
+			if err := json.Unmarshal([]byte(tc.sample), &p); err != nil {
+				t.Fatal(err)
+			}
+			e, err := p.event()
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := e.Data
+			if e.ID != "e4b1c2d3a5f6" || e.Name != "web" {
+				t.Fatalf("expected the stats of e4b1c2d3a5f6 named web, got %s %s", e.ID, e.Name)
+			}
+			if v.CPU.Usage.Total != tc.cpu {
+				t.Fatalf("expected cpu usage %d, got %d", tc.cpu, v.CPU.Usage.Total)
+			}
+			// podman runs on this host, the system cpu usage of the host is
+			// read and scaled by its cpus.
+			if e.systemUsage != 0 || len(v.CPU.Usage.Percpu) != runtime.NumCPU() {
+				t.Fatalf("expected the cpus of this host, got %d cpus and system usage %d", len(v.CPU.Usage.Percpu), e.systemUsage)
+			}
+			if v.Memory.Usage.Usage != tc.memory || v.Memory.Usage.Limit != tc.limit {
+				t.Fatalf("expected memory %d / %d, got %d / %d", tc.memory, tc.limit, v.Memory.Usage.Usage, v.Memory.Usage.Limit)
+			}
+			if c := mstats.Counters(v); c.NetworkRx != tc.rx || c.NetworkTx != tc.tx {
+				t.Fatalf("expected network %d / %d, got %d / %d", tc.rx, tc.tx, c.NetworkRx, c.NetworkTx)
+			}
+			if read, write := mstats.BlockIO(v.Blkio); read != tc.read || write != tc.write {
+				t.Fatalf("expected block I/O %d / %d, got %d / %d", tc.read, tc.write, read, write)
+			}
+			if v.Pids.Current != tc.pids {
+				t.Fatalf("expected %d pids, got %d", tc.pids, v.Pids.Current)
+			}
+		})
+	}
+}
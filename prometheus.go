@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// promMetric is a metric of the containers exposed to Prometheus.
+type promMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func(c *containerStats) float64
+}
+
+var promMetrics = []promMetric{
+	{"magneto_cpu_percent", "CPU usage of the container as a percentage of one cpu.", "gauge",
+		func(c *containerStats) float64 { return c.CPUPercentage }},
+	{"magneto_cpu_throttled_ratio", "Ratio of the cpu periods the container was throttled in.", "gauge",
+		func(c *containerStats) float64 { return c.ThrottledRatio }},
+	{"magneto_memory_usage_bytes", "Memory usage of the container without the page cache.", "gauge",
+		func(c *containerStats) float64 { return c.Memory }},
+	{"magneto_memory_limit_bytes", "Memory limit of the container.", "gauge",
+		func(c *containerStats) float64 { return c.MemoryLimit }},
+	{"magneto_memory_percent", "Memory usage of the container as a percentage of the limit.", "gauge",
+		func(c *containerStats) float64 { return c.MemoryPercentage }},
+	{"magneto_network_receive_bytes_total", "Bytes received by the container.", "counter",
+		func(c *containerStats) float64 { return c.NetworkRx }},
+	{"magneto_network_transmit_bytes_total", "Bytes sent by the container.", "counter",
+		func(c *containerStats) float64 { return c.NetworkTx }},
+	{"magneto_block_read_bytes_total", "Bytes read from block devices by the container.", "counter",
+		func(c *containerStats) float64 { return c.BlockRead }},
+	{"magneto_block_write_bytes_total", "Bytes written to block devices by the container.", "counter",
+		func(c *containerStats) float64 { return c.BlockWrite }},
+	{"magneto_pids", "Number of pids in the container.", "gauge",
+		func(c *containerStats) float64 { return float64(c.PidsCurrent) }},
+	{"magneto_pids_limit", "Pids limit of the container, 0 if unlimited.", "gauge",
+		func(c *containerStats) float64 { return float64(c.PidsLimit) }},
+	{"magneto_block_io_service_latency_seconds", "Average time between dispatch and completion of the I/Os of the container since the previous sample.", "gauge",
+		func(c *containerStats) float64 { return c.BlockIODetail.ServiceLatency / 1e9 }},
+	{"magneto_block_io_wait_latency_seconds", "Average time the I/Os of the container waited in the scheduler queues since the previous sample.", "gauge",
+		func(c *containerStats) float64 { return c.BlockIODetail.WaitLatency / 1e9 }},
+	{"magneto_block_io_queue_depth", "Number of I/Os of the container currently queued.", "gauge",
+		func(c *containerStats) float64 { return float64(c.BlockIODetail.QueueDepth) }},
+	{"magneto_block_io_merge_percent", "Percentage of the I/Os of the container merged into other requests since the previous sample.", "gauge",
+		func(c *containerStats) float64 { return c.BlockIODetail.MergePercentage }},
+	{"magneto_block_io_utilization_percent", "Percentage of time the busiest device spent servicing I/O for the container since the previous sample.", "gauge",
+		func(c *containerStats) float64 { return c.BlockIODetail.Utilization }},
+	{"magneto_intel_rdt_l3_cache_allocation_percent", "Percentage of the L3 cache ways allocated to the container.", "gauge",
+		func(c *containerStats) float64 { return c.IntelRdt.L3CacheAllocation }},
+	{"magneto_intel_rdt_llc_occupancy_bytes", "Last level cache occupancy of the container.", "gauge",
+		func(c *containerStats) float64 { return float64(c.IntelRdt.LLCOccupancy) }},
+	{"magneto_intel_rdt_memory_bandwidth_bytes", "Total memory bandwidth of the container in bytes per second.", "gauge",
+		func(c *containerStats) float64 { return c.IntelRdt.MemoryBandwidth }},
+	{"magneto_oom_kills_total", "OOM kills of the container.", "counter",
+		func(c *containerStats) float64 { return float64(c.OOMKills) }},
+	{"magneto_stale", "Whether the container stopped receiving samples.", "gauge",
+		func(c *containerStats) float64 {
+			if c.Stale {
+				return 1
+			}
+			return 0
+		}},
+	{"magneto_last_sample_timestamp_seconds", "Time of the last sample of the container.", "gauge",
+		func(c *containerStats) float64 { return float64(c.LastSample.UnixNano()) / 1e9 }},
+}
+
+// promHugetlbMetrics are the metrics of the huge pages of the containers,
+// labeled with the page size.
+var promHugetlbMetrics = []struct {
+	name  string
+	help  string
+	typ   string
+	value func(h hugetlbStats) float64
+}{
+	{"magneto_hugetlb_usage_bytes", "Huge pages usage of the container.", "gauge",
+		func(h hugetlbStats) float64 { return float64(h.Usage) }},
+	{"magneto_hugetlb_limit_bytes", "Huge pages limit of the container, 0 if unlimited.", "gauge",
+		func(h hugetlbStats) float64 { return float64(h.Limit) }},
+	{"magneto_hugetlb_failures_total", "Huge pages allocation failures of the container.", "counter",
+		func(h hugetlbStats) float64 { return float64(h.Failcnt) }},
+}
+
+// prometheusSink serves the statistics in the Prometheus text format. The
+// statistics are read on every scrape, so Update does nothing.
+type prometheusSink struct {
+	srv *http.Server
+}
+
+// newPrometheusSink serves the metrics of the statistics on /metrics at addr.
+func newPrometheusSink(addr string, s *stats) (*prometheusSink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.WritePrometheus(w); err != nil {
+			logrus.Errorf("writing the metrics failed: %v", err)
+		}
+	})
+
+	p := &prometheusSink{srv: &http.Server{Handler: mux}}
+	go func() {
+		if err := p.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("serving the metrics failed: %v", err)
+		}
+	}()
+	return p, nil
+}
+
+func (p *prometheusSink) Update(s *stats) error {
+	return nil
+}
+
+func (p *prometheusSink) Close(s *stats) error {
+	return p.srv.Close()
+}
+
+// WritePrometheus writes the metrics of every container in the Prometheus
+// text format.
+func (s *stats) WritePrometheus(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	containers := s.sorted()
+	for _, m := range promMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, c := range containers {
+			fmt.Fprintf(bw, "%s{container=%s,name=%s,source=%s} %s\n",
+				m.name, promLabelValue(c.ID), promLabelValue(c.Name), promLabelValue(c.Source), strconv.FormatFloat(m.value(c), 'g', -1, 64))
+		}
+	}
+
+	for _, m := range promHugetlbMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, c := range containers {
+			for _, h := range c.Hugetlb {
+				fmt.Fprintf(bw, "%s{container=%s,name=%s,source=%s,page_size=%s} %s\n",
+					m.name, promLabelValue(c.ID), promLabelValue(c.Name), promLabelValue(c.Source), promLabelValue(h.PageSize), strconv.FormatFloat(m.value(h), 'g', -1, 64))
+			}
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP magneto_rule_firing Whether the rule is firing for the container.\n# TYPE magneto_rule_firing gauge\n")
+	for _, c := range containers {
+		for i, st := range c.rules {
+			firing := 0
+			if st.State == ruleFiring {
+				firing = 1
+			}
+			fmt.Fprintf(bw, "magneto_rule_firing{container=%s,name=%s,source=%s,rule=%s} %d\n",
+				promLabelValue(c.ID), promLabelValue(c.Name), promLabelValue(c.Source), promLabelValue(rules[i].name), firing)
+		}
+	}
+	return bw.Flush()
+}
+
+// promLabelValue quotes a label value.
+func promLabelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	s := newStats()
+	s.containers["abc"] = &containerStats{
+		ID:            "abc",
+		Name:          `we"b`,
+		Source:        "stdin",
+		CPUPercentage: 12.5,
+		BlockIODetail: blkioDetail{ServiceLatency: 2e6, QueueDepth: 3, Utilization: 40},
+		Hugetlb:       []hugetlbStats{{PageSize: "2MB", Usage: 4194304, Limit: 8388608, Failcnt: 1}},
+		IntelRdt:      intelRdtStats{LLCOccupancy: 1024},
+		LastSample:    time.Unix(1000, 500000000),
+	}
+
+	var buf bytes.Buffer
+	if err := s.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	labels := `{container="abc",name="we\"b",source="stdin"}`
+	for _, want := range []string{
+		"# TYPE magneto_cpu_percent gauge\n",
+		"magneto_cpu_percent" + labels + " 12.5\n",
+		"magneto_block_io_service_latency_seconds" + labels + " 0.002\n",
+		"magneto_block_io_queue_depth" + labels + " 3\n",
+		"magneto_block_io_utilization_percent" + labels + " 40\n",
+		"magneto_intel_rdt_llc_occupancy_bytes" + labels + " 1024\n",
+		"# TYPE magneto_hugetlb_failures_total counter\n",
+		`magneto_hugetlb_usage_bytes{container="abc",name="we\"b",source="stdin",page_size="2MB"} 4.194304e+06` + "\n",
+		`magneto_hugetlb_failures_total{container="abc",name="we\"b",source="stdin",page_size="2MB"} 1` + "\n",
+		"magneto_last_sample_timestamp_seconds" + labels + " 1000.5\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const recordShortHelp = `Record the runc events from stdin to a capture file.`
+
+var recordHelp = recordShortHelp + `
+
+Every event is saved with the time it was received, its source, the name and
+labels of its container and the host system cpu usage at that time so it can
+be replayed later. Capture files
+ending in ".gz" are gzip compressed.`
+
+func (cmd *recordCommand) Name() string      { return "record" }
+func (cmd *recordCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *recordCommand) ShortHelp() string { return recordShortHelp }
+func (cmd *recordCommand) LongHelp() string  { return recordHelp }
+func (cmd *recordCommand) Hidden() bool      { return false }
+
+func (cmd *recordCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.output, "o", "", "capture file to write the events to")
+}
+
+type recordCommand struct {
+	output string
+}
+
+func (cmd *recordCommand) Run(ctx context.Context, args []string) error {
+	if cmd.output == "" {
+		return fmt.Errorf("pass the capture file to write to with -o")
+	}
+
+	s := newStats()
+	w, err := newCaptureWriter(cmd.output, s.systemCPU.ClockTicks)
+	if err != nil {
+		return err
+	}
+
+	// On ^C, or SIGTERM close the capture file before exiting.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, syscall.SIGTERM)
+	go func() {
+		for sig := range c {
+			logrus.Infof("Received %s, exiting.", sig.String())
+			if err := w.Close(); err != nil {
+				logrus.Error(err)
+			}
+			os.Exit(0)
+		}
+	}()
+
+	type containerName struct {
+		name   string
+		labels map[string]string
+	}
+	names := map[string]containerName{}
+
+	er := newEventReader(os.Stdin)
+	for {
+		e, err := er.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			w.Close()
+			return fmt.Errorf("reading events failed: %v", err)
+		}
+
+		r := captureRecord{
+			Time:   time.Now(),
+			Source: "stdin",
+			Event:  e,
+		}
+		if e.Type == "stats" {
+			r.SystemCPUUsage, err = s.systemCPU.Read()
+			if err != nil {
+				w.Close()
+				return fmt.Errorf("collecting system cpu usage failed: %v", err)
+			}
+		}
+
+		// Save the name and the labels found on this host, which are not
+		// read when replaying.
+		if e.Type == "stats" && e.Name == "" {
+			n, ok := names[e.ID]
+			if !ok {
+				state, err := loadState(root, e.ID)
+				if err != nil {
+					logrus.Debugf("reading state for container %s failed: %v", e.ID, err)
+				}
+				n.name, n.labels = resolveName(e.ID, state)
+				names[e.ID] = n
+			}
+			r.Event.Name, r.Event.Labels = n.name, n.labels
+		}
+
+		if err := w.Write(r); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	if er.malformed > 0 {
+		logrus.Warnf("skipped %d malformed events", er.malformed)
+	}
+
+	return w.Close()
+}
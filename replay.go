@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// replaySeek is how far the left and right keys seek in the capture.
+const replaySeek = 10 * time.Second
+
+const replayShortHelp = `Replay the events from a capture file.`
+
+var replayHelp = replayShortHelp + `
+
+The events are calculated and displayed the same way as a live stream, using
+the host system cpu usage recorded along with them. The flags can be passed
+before or after the capture file.
+
+Keys:
+
+  space       pause or resume
+  n           step to the next event
+  left/right  seek 10s backward or forward
+  +/-         double or halve the speed
+  t           switch between the I/O rates and totals
+  q           quit`
+
+func (cmd *replayCommand) Name() string      { return "replay" }
+func (cmd *replayCommand) Args() string      { return "[OPTIONS] CAPTURE" }
+func (cmd *replayCommand) ShortHelp() string { return replayShortHelp }
+func (cmd *replayCommand) LongHelp() string  { return replayHelp }
+func (cmd *replayCommand) Hidden() bool      { return false }
+
+func (cmd *replayCommand) Register(fs *flag.FlagSet) {
+	cmd.fs = fs
+	fs.StringVar(&cmd.speed, "speed", "1x", "replay speed, such as 0.5x or 4x")
+}
+
+type replayCommand struct {
+	fs    *flag.FlagSet
+	speed string
+}
+
+func (cmd *replayCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the capture file to replay")
+	}
+
+	// Parse the flags passed after the capture file.
+	if len(args) > 1 {
+		if err := cmd.fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if cmd.fs.NArg() > 0 {
+			return fmt.Errorf("unexpected arguments: %s", strings.Join(cmd.fs.Args(), " "))
+		}
+	}
+
+	speed, err := parseSpeed(cmd.speed)
+	if err != nil {
+		return err
+	}
+
+	header, records, err := readCapture(args[0])
+	if err != nil {
+		return err
+	}
+	if len(records) < 1 {
+		return fmt.Errorf("%s has no events to replay", args[0])
+	}
+
+	r := &replayer{
+		header:  header,
+		records: records,
+		speed:   speed,
+		s:       newCaptureStats(),
+		sink:    newDisplaySink(),
+	}
+	r.s.now = r.position
+
+	if ruleEvents != "" {
+		if err := r.s.writeRuleEvents(ruleEvents); err != nil {
+			return err
+		}
+	}
+	if err := r.s.addWebhooks(webhooks, webhookTemplate); err != nil {
+		return err
+	}
+	r.s.addHooks(hooks, hookTimeout, hookConcurrency)
+
+	return r.run()
+}
+
+// parseSpeed parses a replay speed such as "4x", "0.5x" or "2".
+func parseSpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q, must be a positive number like 0.5x or 4x", s)
+	}
+	return speed, nil
+}
+
+// replayer feeds the records of a capture through the statistics at the
+// pace they were recorded at.
+type replayer struct {
+	header  captureHeader
+	records []captureRecord
+	// next is the index of the next record to process.
+	next   int
+	speed  float64
+	paused bool
+	s      *stats
+	sink   Sink
+}
+
+func (r *replayer) run() error {
+	var keys <-chan string
+	if k := newKeyReader(os.Stdin); k != nil {
+		keys = k.keys()
+		defer k.restore()
+
+		// On ^C, or SIGTERM restore the terminal before exiting.
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		signal.Notify(c, syscall.SIGTERM)
+		go func() {
+			for sig := range c {
+				logrus.Infof("Received %s, exiting.", sig.String())
+				k.restore()
+				shutdown(r.s)
+				os.Exit(0)
+			}
+		}()
+	}
+
+	r.step()
+	r.render()
+
+	var lastRender time.Time
+	for {
+		if r.done() && keys == nil {
+			shutdown(r.s)
+			return nil
+		}
+
+		var (
+			timer  *time.Timer
+			timerC <-chan time.Time
+		)
+		if !r.paused && !r.done() {
+			timer = time.NewTimer(r.delay())
+			timerC = timer.C
+		}
+
+		select {
+		case <-timerC:
+			r.step()
+			// Limit how often the display is redrawn at high speeds.
+			if time.Since(lastRender) < minRedrawInterval && !r.done() {
+				continue
+			}
+		case k, ok := <-keys:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				keys = nil
+				continue
+			}
+			if k == "q" {
+				shutdown(r.s)
+				return nil
+			}
+			r.handleKey(k)
+		}
+
+		r.render()
+		lastRender = time.Now()
+	}
+}
+
+func (r *replayer) done() bool {
+	return r.next >= len(r.records)
+}
+
+// delay returns how long to wait before processing the next record.
+func (r *replayer) delay() time.Duration {
+	if r.next == 0 {
+		return 0
+	}
+	d := r.records[r.next].Time.Sub(r.records[r.next-1].Time)
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(float64(d) / r.speed)
+}
+
+// position returns the time of the last processed record.
+func (r *replayer) position() time.Time {
+	if r.next == 0 {
+		return r.records[0].Time
+	}
+	return r.records[r.next-1].Time
+}
+
+// step processes the next record.
+func (r *replayer) step() {
+	if r.done() {
+		return
+	}
+	r.s.process(r.records[r.next])
+	r.next++
+}
+
+// seek moves the replay by d. Seeking backward replays the capture from the
+// start since the statistics depend on the previous samples, without sending
+// the events that were already sent again.
+func (r *replayer) seek(d time.Duration) {
+	target := r.position().Add(d)
+	if d < 0 {
+		r.s.reset()
+		r.next = 0
+		r.s.replaying = true
+		defer func() { r.s.replaying = false }()
+	}
+	for !r.done() && !r.records[r.next].Time.After(target) {
+		r.step()
+	}
+	if r.next == 0 {
+		r.step()
+	}
+}
+
+func (r *replayer) handleKey(k string) {
+	switch k {
+	case " ", "p":
+		r.paused = !r.paused
+	case "n":
+		r.paused = true
+		r.step()
+	case keyLeft, "h":
+		r.seek(-replaySeek)
+	case keyRight, "l":
+		r.seek(replaySeek)
+	case "+":
+		r.speed = r.speed * 2
+	case "-":
+		r.speed = r.speed / 2
+	case "t":
+		totals = !totals
+	}
+}
+
+// render displays the statistics followed by the replay status.
+func (r *replayer) render() {
+	r.s.checkStale(r.position(), staleIntervals)
+	if r.sink == nil {
+		return
+	}
+	if err := r.sink.Update(r.s); err != nil {
+		logrus.Error(err)
+	}
+	if format != "table" {
+		return
+	}
+
+	state := "playing"
+	switch {
+	case r.done():
+		state = "finished"
+	case r.paused:
+		state = "paused"
+	}
+
+	fmt.Fprintf(os.Stdout, "\nREPLAY %s / %s   event %d / %d   %gx   %s   (captured on %s with %d cpus)\n",
+		r.position().Sub(r.records[0].Time).Round(time.Second),
+		r.records[len(r.records)-1].Time.Sub(r.records[0].Time).Round(time.Second),
+		r.next, len(r.records),
+		r.speed,
+		state,
+		r.header.Hostname, r.header.NumCPU)
+	fmt.Fprintln(os.Stdout, "space pause   n step   left/right seek 10s   +/- speed   t totals   q quit")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func TestReplaySeekBackward(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magneto-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1000, 0)
+	record := func(at time.Duration, typ string) captureRecord {
+		return captureRecord{Time: start.Add(at), Event: event{Type: typ, ID: "abc", Data: types.Stats{}}}
+	}
+	r := &replayer{
+		records: []captureRecord{
+			record(0, "stats"),
+			record(5*time.Second, "oom"),
+			record(10*time.Second, "stats"),
+			record(20*time.Second, "stats"),
+			record(30*time.Second, "stats"),
+		},
+		speed: 1,
+		s:     newCaptureStats(),
+	}
+	r.s.now = r.position
+
+	capture := filepath.Join(dir, "capture.jsonl")
+	if r.s.recorder, err = newCaptureWriter(capture, 100); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	r.s.ruleListeners = append(r.s.ruleListeners, func(e ruleEvent) {
+		events = append(events, e.Rule)
+	})
+	r.s.lifecycleListeners = append(r.s.lifecycleListeners, func(e lifecycleEvent) {
+		events = append(events, e.Event)
+	})
+
+	for !r.done() {
+		r.step()
+	}
+	expected := []string{hookAppear, ruleOOM}
+	if len(events) != len(expected) || events[0] != expected[0] || events[1] != expected[1] {
+		t.Fatalf("expected the events %v, got %v", expected, events)
+	}
+
+	// Seeking back processes the records up to the target again, without
+	// sending their events or recording them again.
+	r.seek(-10 * time.Second)
+	if r.next != 4 {
+		t.Fatalf("expected to seek to the record at 20s, got %d", r.next)
+	}
+	if c := r.s.containers["abc"]; c == nil || c.OOMKills != 1 {
+		t.Fatalf("expected the statistics to be calculated again, got %+v", c)
+	}
+	r.seek(-time.Minute)
+	if len(events) != len(expected) {
+		t.Fatalf("expected no events to be sent again, got %v", events)
+	}
+
+	// The events after the seek are sent and recorded again.
+	r.seek(10 * time.Second)
+	if len(events) != 3 || events[2] != ruleOOM {
+		t.Fatalf("expected the oom event after seeking forward, got %v", events)
+	}
+
+	if err := r.s.recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, records, err := readCapture(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The records are recorded once, and the records at 5s and 10s again
+	// after seeking forward.
+	if len(records) != len(r.records)+2 {
+		t.Fatalf("expected %d records, got %d", len(r.records)+2, len(records))
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHysteresis is the fraction of the threshold a value has to move
+// back past before a firing rule resolves, when the rule has no clear value.
+const defaultHysteresis = 0.05
+
+// The states of a rule for a container.
+const (
+	ruleInactive = "inactive"
+	rulePending  = "pending"
+	ruleFiring   = "firing"
+	ruleResolved = "resolved"
+)
+
+// ruleOOM is the rule of the events emitted when a container is OOM killed,
+// with the memory usage and limit of its last sample as value and threshold.
+const ruleOOM = "oom"
+
+// ruleMetric is a metric that rules can be defined on.
+type ruleMetric struct {
+	value func(c *containerStats) float64
+	// unit is how values for the metric are written: "%" for percentages,
+	// "ratio" for ratios where a "%" value is divided by 100, "bytes" for
+	// sizes like 512MiB and "" for plain numbers.
+	unit string
+}
+
+var ruleMetrics = map[string]ruleMetric{
+	"cpu":             {value: func(c *containerStats) float64 { return c.CPUPercentage }, unit: "%"},
+	"mem":             {value: func(c *containerStats) float64 { return c.Memory }, unit: "bytes"},
+	"mem_pct":         {value: func(c *containerStats) float64 { return c.MemoryPercentage }, unit: "%"},
+	"pids":            {value: func(c *containerStats) float64 { return float64(c.PidsCurrent) }},
+	"pids_pct":        {value: func(c *containerStats) float64 { return c.PidsPercentage }, unit: "%"},
+	"throttled_ratio": {value: func(c *containerStats) float64 { return c.ThrottledRatio }, unit: "ratio"},
+	"net_rx":          {value: func(c *containerStats) float64 { return c.Rates.NetworkRx }, unit: "bytes"},
+	"net_tx":          {value: func(c *containerStats) float64 { return c.Rates.NetworkTx }, unit: "bytes"},
+	"block_read":      {value: func(c *containerStats) float64 { return c.Rates.BlockRead }, unit: "bytes"},
+	"block_write":     {value: func(c *containerStats) float64 { return c.Rates.BlockWrite }, unit: "bytes"},
+	"block_util":      {value: func(c *containerStats) float64 { return c.BlockIODetail.Utilization }, unit: "%"},
+}
+
+// rule is a threshold on a metric that fires when it is crossed for longer
+// than its duration, like "cpu > 90% for 30s".
+type rule struct {
+	name      string
+	metric    string
+	op        string
+	threshold float64
+	clear     float64
+	duration  time.Duration
+}
+
+// parseRule parses a rule in the format:
+//
+//	[name:] metric op value [for duration] [clear value]
+//
+// where op is one of >, >=, < or <=. The rule resolves once the value moves
+// back past the clear value, which defaults to 5% of the threshold, or once
+// it stops crossing a threshold of 0.
+func parseRule(s string) (*rule, error) {
+	r := &rule{name: strings.TrimSpace(s)}
+	if i := strings.Index(s, ":"); i >= 0 {
+		r.name = strings.TrimSpace(s[:i])
+		s = s[i+1:]
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid rule %q, must be like \"cpu > 90%% for 30s\"", s)
+	}
+
+	values := []string{fields[2]}
+	for i := 3; i+1 < len(fields); i += 2 {
+		if fields[i] == "clear" {
+			values = append(values, fields[i+1])
+		}
+	}
+	r.metric = percentMetric(fields[0], values...)
+	m, ok := ruleMetrics[r.metric]
+	if !ok {
+		return nil, fmt.Errorf("invalid rule %q, unknown metric %s, must be one of %s", s, r.metric, strings.Join(ruleMetricNames(), ", "))
+	}
+
+	r.op = fields[1]
+	switch r.op {
+	case ">", ">=", "<", "<=":
+	default:
+		return nil, fmt.Errorf("invalid rule %q, unknown operator %s, must be one of >, >=, < or <=", s, r.op)
+	}
+
+	var err error
+	if r.threshold, err = parseRuleValue(fields[2], m.unit); err != nil {
+		return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+	hysteresis := r.threshold * defaultHysteresis
+	if r.op == "<" || r.op == "<=" {
+		hysteresis = -hysteresis
+	}
+	r.clear = r.threshold - hysteresis
+
+	for rest := fields[3:]; len(rest) > 0; rest = rest[2:] {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("invalid rule %q, missing value for %s", s, rest[0])
+		}
+		switch rest[0] {
+		case "for":
+			if r.duration, err = time.ParseDuration(rest[1]); err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+			}
+		case "clear":
+			if r.clear, err = parseRuleValue(rest[1], m.unit); err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid rule %q, unknown clause %s, must be for or clear", s, rest[0])
+		}
+	}
+
+	return r, nil
+}
+
+// percentMetric returns mem_pct for the mem metric when all the values are
+// plain numbers or percentages, like mem=70 or mem > 70%, which mean the
+// percentage of the limit rather than bytes. Other metrics are returned as
+// they are.
+func percentMetric(metric string, values ...string) string {
+	if metric != "mem" {
+		return metric
+	}
+	plain := false
+	for _, v := range values {
+		if v == "" || v == "~" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err != nil {
+			return metric
+		}
+		plain = true
+	}
+	if plain {
+		return "mem_pct"
+	}
+	return metric
+}
+
+// parseRuleValue parses a threshold for a metric with the given unit.
+func parseRuleValue(s, unit string) (float64, error) {
+	if unit == "bytes" {
+		v, err := units.RAMInBytes(strings.TrimSuffix(s, "/s"))
+		if err != nil {
+			return 0, err
+		}
+		return float64(v), nil
+	}
+
+	percent := strings.HasSuffix(s, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	if percent && unit == "ratio" {
+		v = v / 100.0
+	}
+	return v, nil
+}
+
+func ruleMetricNames() []string {
+	names := make([]string, 0, len(ruleMetrics))
+	for name := range ruleMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// crossed returns whether the value crosses the threshold of the rule.
+func (r *rule) crossed(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	}
+	return v <= r.threshold
+}
+
+// cleared returns whether the value moved back past the clear value of the
+// rule. With a clear value at the threshold, like the default one of a
+// threshold of 0, the value only has to stop crossing the threshold.
+func (r *rule) cleared(v float64) bool {
+	if r.clear == r.threshold {
+		return !r.crossed(v)
+	}
+	if r.op == ">" || r.op == ">=" {
+		return v < r.clear
+	}
+	return v > r.clear
+}
+
+// ruleState is the state of a rule for a container.
+type ruleState struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	Value float64   `json:"value"`
+}
+
+// ruleEvent is emitted when the state of a rule for a container changes.
+type ruleEvent struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Name      string    `json:"name,omitempty"`
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Previous  string    `json:"previous"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+}
+
+// evaluate updates the state of the rule for the container with the sample
+// read at now, returning an event if the state changed.
+func (r *rule) evaluate(c *containerStats, st *ruleState, now time.Time) (ruleEvent, bool) {
+	var (
+		v        = ruleMetrics[r.metric].value(c)
+		previous = st.State
+	)
+	st.Value = v
+
+	switch st.State {
+	case ruleFiring:
+		if r.cleared(v) {
+			st.State = ruleResolved
+		}
+	case rulePending:
+		if !r.crossed(v) {
+			st.State = ruleInactive
+		} else if now.Sub(st.Since) >= r.duration {
+			st.State = ruleFiring
+		}
+	default:
+		if r.crossed(v) {
+			st.State = rulePending
+			if r.duration <= 0 {
+				st.State = ruleFiring
+			}
+		}
+	}
+
+	if st.State == previous {
+		return ruleEvent{}, false
+	}
+	st.Since = now
+
+	e := ruleEvent{
+		Time:      now,
+		Container: c.ID,
+		Name:      c.Name,
+		Rule:      r.name,
+		State:     st.State,
+		Previous:  previous,
+		Value:     v,
+		Threshold: r.threshold,
+	}
+	if st.State == ruleResolved {
+		st.State = ruleInactive
+	}
+	return e, true
+}
+
+// evaluateRules evaluates all the rules for the container.
+// It must be called with the lock held.
+func (c *containerStats) evaluateRules(now time.Time) []ruleEvent {
+	if c.rules == nil {
+		c.rules = make([]ruleState, len(rules))
+		for i := range c.rules {
+			c.rules[i].State = ruleInactive
+		}
+	}
+
+	var events []ruleEvent
+	c.Alerts = nil
+	for i, r := range rules {
+		if e, ok := r.evaluate(c, &c.rules[i], now); ok {
+			events = append(events, e)
+		}
+		if c.rules[i].State == ruleFiring {
+			c.Alerts = append(c.Alerts, r.name)
+		}
+	}
+	return events
+}
+
+// emitRuleEvent logs the rule event and sends it to the listeners.
+func (s *stats) emitRuleEvent(e ruleEvent) {
+	if s.replaying {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"container": e.Container,
+		"name":      e.Name,
+		"rule":      e.Rule,
+		"state":     e.State,
+		"previous":  e.Previous,
+		"value":     e.Value,
+		"threshold": e.Threshold,
+	}).Infof("rule %s is %s for container %s", e.Rule, e.State, e.Container)
+
+	for _, l := range s.ruleListeners {
+		l(e)
+	}
+}
+
+// ruleEventWriter writes the rule events as lines of JSON.
+type ruleEventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newRuleEventWriter(w io.Writer) *ruleEventWriter {
+	return &ruleEventWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *ruleEventWriter) write(e ruleEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(e); err != nil {
+		logrus.Errorf("writing rule event failed: %v", err)
+	}
+}
+
+// writeRuleEvents appends the rule events to the file at path as lines of
+// JSON, or to stdout if path is "-".
+func (s *stats) writeRuleEvents(path string) error {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	w := newRuleEventWriter(out)
+	s.ruleListeners = append(s.ruleListeners, w.write)
+	return nil
+}
+
+// DisplayRules writes the pending and firing rules below the table.
+func (s *stats) DisplayRules(w io.Writer) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rows []string
+	for _, c := range s.sorted() {
+		for i, st := range c.rules {
+			if st.State != rulePending && st.State != ruleFiring {
+				continue
+			}
+			state := colorYellow + st.State + colorReset
+			if st.State == ruleFiring {
+				state = colorRed + st.State + colorReset
+			}
+			rows = append(rows, fmt.Sprintf("%s\t%s\t%.2f\t%s\t%s\n",
+				c.displayName(), rules[i].name, st.Value, st.Since.Format("15:04:05"), state))
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	io.WriteString(w, "\nCONTAINER\tRULE\tVALUE\tSINCE\tSTATE\n")
+	for _, row := range rows {
+		io.WriteString(w, row)
+	}
+}
+
+// ruleFlag collects the rules passed with the repeatable rule flag.
+type ruleFlag []*rule
+
+func (f *ruleFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, r := range *f {
+		names = append(names, r.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (f *ruleFlag) Set(s string) error {
+	r, err := parseRule(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
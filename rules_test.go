@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	testCases := []struct {
+		rule    string
+		want    rule
+		wantErr bool
+	}{
+		{
+			rule: "cpu > 90% for 30s",
+			want: rule{name: "cpu > 90% for 30s", metric: "cpu", op: ">", threshold: 90, clear: 85.5, duration: 30 * time.Second},
+		},
+		{
+			rule: "heap: mem_pct >= 80 clear 70",
+			want: rule{name: "heap", metric: "mem_pct", op: ">=", threshold: 80, clear: 70},
+		},
+		{
+			rule: "low: pids < 100",
+			want: rule{name: "low", metric: "pids", op: "<", threshold: 100, clear: 105},
+		},
+		{
+			rule: "throttled_ratio > 20%",
+			want: rule{name: "throttled_ratio > 20%", metric: "throttled_ratio", op: ">", threshold: 0.2, clear: 0.19},
+		},
+		{
+			rule: "mem > 70 clear 60%",
+			want: rule{name: "mem > 70 clear 60%", metric: "mem_pct", op: ">", threshold: 70, clear: 60},
+		},
+		{
+			rule: "mem > 1GiB",
+			want: rule{name: "mem > 1GiB", metric: "mem", op: ">", threshold: 1 << 30, clear: 1 << 30 * 0.95},
+		},
+		{rule: "cpu > 90 for", wantErr: true},
+		{rule: "cpu = 90", wantErr: true},
+		{rule: "disk > 90", wantErr: true},
+		{rule: "cpu > 90 until 1m", wantErr: true},
+		{rule: "cpu >", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.rule, func(t *testing.T) {
+			r, err := parseRule(tc.rule)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !approxEqual(r.clear, tc.want.clear) {
+				t.Fatalf("expected clear value %v, got %v", tc.want.clear, r.clear)
+			}
+			r.clear = tc.want.clear
+			if *r != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, *r)
+			}
+		})
+	}
+}
+
+func TestRuleEvaluate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	type sample struct {
+		value float64
+		// state is the state of the rule after the sample, with an event
+		// if it changed.
+		state string
+		event bool
+	}
+	testCases := []struct {
+		name    string
+		rule    string
+		samples []sample
+	}{
+		{
+			name: "fires right away without a duration",
+			rule: "cpu > 90",
+			samples: []sample{
+				{50, ruleInactive, false},
+				{95, ruleFiring, true},
+				{96, ruleFiring, false},
+			},
+		},
+		{
+			name: "pending for the duration",
+			rule: "cpu > 90 for 2s",
+			samples: []sample{
+				{95, rulePending, true},
+				{95, rulePending, false},
+				{95, ruleFiring, true},
+			},
+		},
+		{
+			name: "pending resets below the threshold",
+			rule: "cpu > 90 for 2s",
+			samples: []sample{
+				{95, rulePending, true},
+				{80, ruleInactive, true},
+				{95, rulePending, true},
+				{95, rulePending, false},
+			},
+		},
+		{
+			name: "hysteresis keeps firing around the threshold",
+			rule: "cpu > 90",
+			samples: []sample{
+				{95, ruleFiring, true},
+				// Below the threshold but above the default clear value of 85.5.
+				{89, ruleFiring, false},
+				{86, ruleFiring, false},
+				{85, ruleInactive, true},
+				{89, ruleInactive, false},
+			},
+		},
+		{
+			name: "threshold of 0 resolves below it",
+			rule: "cpu > 0",
+			samples: []sample{
+				{0, ruleInactive, false},
+				{0.5, ruleFiring, true},
+				{0, ruleInactive, true},
+			},
+		},
+		{
+			name: "clear value at the threshold",
+			rule: "cpu >= 90 clear 90",
+			samples: []sample{
+				{90, ruleFiring, true},
+				{89.9, ruleInactive, true},
+			},
+		},
+		{
+			name: "clear value below the threshold",
+			rule: "pids < 10 clear 20",
+			samples: []sample{
+				{5, ruleFiring, true},
+				{15, ruleFiring, false},
+				{21, ruleInactive, true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := parseRule(tc.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := &ruleState{State: ruleInactive}
+			for i, s := range tc.samples {
+				c := &containerStats{ID: "abc", CPUPercentage: s.value, PidsCurrent: uint64(s.value)}
+				e, changed := r.evaluate(c, st, start.Add(time.Duration(i)*time.Second))
+				if changed != s.event {
+					t.Fatalf("sample %d: expected an event %v, got %v (%+v)", i, s.event, changed, e)
+				}
+				if st.State != s.state {
+					t.Fatalf("sample %d: expected state %s, got %s", i, s.state, st.State)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+)
+
+// Sink is where the statistics are written to.
+type Sink interface {
+	// Update is called with the statistics on every refresh.
+	Update(s *stats) error
+	// Close is called with the final statistics once the pipeline stopped.
+	Close(s *stats) error
+}
+
+// newDisplaySink returns the sink writing the statistics to stdout in the
+// output format, nil if the output format is none.
+func newDisplaySink() Sink {
+	switch format {
+	case "json":
+		return &jsonSink{w: os.Stdout}
+	case "none":
+		return nil
+	}
+	return &tableSink{w: tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0), view: newGroupView()}
+}
+
+// tableSink clears the screen and writes the statistics as tables.
+type tableSink struct {
+	// mu serializes the updates of the pipeline and the redraws on the keys.
+	mu   sync.Mutex
+	w    *tabwriter.Writer
+	view *groupView
+	keys *keyReader
+	// last are the statistics of the last update, to redraw on the keys.
+	last *stats
+}
+
+// readKeys reads the keys from the terminal f to switch between the I/O
+// rates and totals and to select, expand and collapse the groups, redrawing
+// the table on every change. It does nothing if f is not a terminal.
+func (t *tableSink) readKeys(f *os.File) {
+	if f == nil {
+		return
+	}
+	k := newKeyReader(f)
+	if k == nil {
+		return
+	}
+	t.keys = k
+	t.view.mu.Lock()
+	t.view.interactive = true
+	t.view.mu.Unlock()
+
+	go func() {
+		for key := range k.keys() {
+			t.mu.Lock()
+			if key == "t" {
+				totals = !totals
+			} else if !t.view.handleKey(key) {
+				t.mu.Unlock()
+				continue
+			}
+			s := t.last
+			t.mu.Unlock()
+			if s != nil {
+				t.Update(s)
+			}
+		}
+	}()
+}
+
+func (t *tableSink) Update(s *stats) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = s
+
+	fmt.Fprint(os.Stdout, "\033[2J")
+	fmt.Fprint(os.Stdout, "\033[H")
+	if s.showSources {
+		io.WriteString(t.w, "SOURCE\t")
+	}
+	io.WriteString(t.w, "CONTAINER\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	s.Display(t.w, t.view)
+	s.DisplayHugetlb(t.w, detail)
+	s.DisplayRules(t.w)
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+
+	if detail {
+		fmt.Fprintln(os.Stdout)
+		s.DisplayDetail(t.w)
+		s.DisplayIntelRdt(t.w)
+		if err := t.w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	s.DisplayStatus(os.Stdout)
+
+	t.view.mu.Lock()
+	if t.view.interactive {
+		help := "t: rates or totals"
+		if len(t.view.names) > 0 {
+			help += ", up/down: select group, space: expand or collapse, +/-: expand or collapse all"
+		}
+		fmt.Fprintf(os.Stdout, "\n%s\n", help)
+	}
+	t.view.mu.Unlock()
+	return nil
+}
+
+// Close puts the terminal back in the state it was in if the keys were read.
+func (t *tableSink) Close(s *stats) error {
+	if t.keys != nil {
+		return t.keys.restore()
+	}
+	return nil
+}
+
+// jsonSink writes the statistics of every container as a line of JSON.
+type jsonSink struct {
+	w io.Writer
+}
+
+func (j *jsonSink) Update(s *stats) error {
+	return s.DisplayJSON(j.w)
+}
+
+func (j *jsonSink) Close(s *stats) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Source is where the events are read from.
+type Source interface {
+	// Name labels the events read from the source.
+	Name() string
+	// Read returns the next event. It returns io.EOF when the source ended.
+	Read() (event, error)
+	// Malformed returns the number of events that were skipped since they
+	// could not be decoded.
+	Malformed() uint64
+	// Close stops the source.
+	Close() error
+}
+
+// openSource opens the source for an input: "-" for stdin, "exec:COMMAND" for
+// the output of a command run with the shell, like
+// "exec:runc events --interval 1s", "unix://PATH" for a unix socket,
+// "docker://[PATH]" for the Docker Engine API on a socket, "kubelet:URL" for
+// the stats summary of a kubelet, "cgroup:PATTERN" for the cgroup v2
+// directories matching the pattern, and a path for a file or a named pipe.
+func openSource(input string) (Source, error) {
+	switch {
+	case input == "-":
+		return newReaderSource("stdin", os.Stdin), nil
+	case strings.HasPrefix(input, "exec:"):
+		return newCommandSource(strings.TrimPrefix(input, "exec:"))
+	case strings.HasPrefix(input, "docker://"):
+		socket := strings.TrimPrefix(input, "docker://")
+		if socket == "" {
+			socket = defaultDockerSocket
+		}
+		return newDockerSource(input, socket)
+	case strings.HasPrefix(input, "kubelet:"):
+		return newKubeletSource(input, strings.TrimPrefix(input, "kubelet:"))
+	case strings.HasPrefix(input, "cgroup:"):
+		return newCgroupSource(input, strings.TrimPrefix(input, "cgroup:"))
+	case strings.HasPrefix(input, "unix://"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(input, "unix://"))
+		if err != nil {
+			return nil, err
+		}
+		return newReaderSource(input, conn), nil
+	}
+
+	fi, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe != 0 {
+		return &pipeSource{path: input}, nil
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	return newReaderSource(input, f), nil
+}
+
+// readerSource reads the events line by line from a stream.
+type readerSource struct {
+	name string
+	r    io.Reader
+	er   *eventReader
+}
+
+func newReaderSource(name string, r io.Reader) *readerSource {
+	return &readerSource{name: name, r: r, er: newEventReader(r)}
+}
+
+func (s *readerSource) Name() string         { return s.name }
+func (s *readerSource) Read() (event, error) { return s.er.Read() }
+func (s *readerSource) Malformed() uint64    { return s.er.malformed }
+
+func (s *readerSource) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// commandSource reads the events from the output of a command.
+type commandSource struct {
+	*readerSource
+	cmd *exec.Cmd
+}
+
+func newCommandSource(command string) (*commandSource, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %q failed: %v", command, err)
+	}
+	return &commandSource{readerSource: newReaderSource(command, out), cmd: cmd}, nil
+}
+
+// Read returns io.EOF once the command exited successfully and the error of
+// the command if it failed.
+func (s *commandSource) Read() (event, error) {
+	e, err := s.readerSource.Read()
+	if err == io.EOF {
+		if werr := s.cmd.Wait(); werr != nil {
+			return e, werr
+		}
+	}
+	return e, err
+}
+
+// Close kills the command along with the processes it started.
+func (s *commandSource) Close() error {
+	syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
+	return nil
+}
+
+// pipeSource reads the events from a named pipe. The pipe is opened on the
+// first read, since opening it blocks until there is a writer.
+type pipeSource struct {
+	path string
+
+	mu     sync.Mutex
+	src    *readerSource
+	closed bool
+}
+
+func (s *pipeSource) Name() string { return s.path }
+
+func (s *pipeSource) Read() (event, error) {
+	s.mu.Lock()
+	src := s.src
+	s.mu.Unlock()
+	if src == nil {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return event{}, err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			f.Close()
+			return event{}, io.EOF
+		}
+		src = newReaderSource(s.path, f)
+		s.src = src
+		s.mu.Unlock()
+	}
+	return src.Read()
+}
+
+func (s *pipeSource) Malformed() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.src == nil {
+		return 0
+	}
+	return s.src.Malformed()
+}
+
+func (s *pipeSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.src == nil {
+		return nil
+	}
+	return s.src.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSampleInterval is the interval runc events sends the stats at by
+// default, used until two samples of a container have been received.
+const defaultSampleInterval = 5 * time.Second
+
+// sampleInterval returns the interval between the last two samples of the
+// container.
+func (c *containerStats) sampleInterval() time.Duration {
+	if c.previousSample.IsZero() || !c.LastSample.After(c.previousSample) {
+		return defaultSampleInterval
+	}
+	return c.LastSample.Sub(c.previousSample)
+}
+
+// staleContainer is a container that became stale.
+type staleContainer struct {
+	ID         string
+	Name       string
+	LastSample time.Time
+}
+
+// checkStale marks the containers that have not received a sample within
+// the given number of sample intervals at now as stale, and returns the
+// containers that became stale after emitting their disappear events.
+func (s *stats) checkStale(now time.Time, intervals int) []staleContainer {
+	s.mu.Lock()
+	var stale []staleContainer
+	for _, c := range s.sorted() {
+		isStale := c.stopped || now.Sub(c.LastSample) > time.Duration(intervals)*c.sampleInterval()
+		if isStale && !c.Stale {
+			stale = append(stale, staleContainer{ID: c.ID, Name: c.Name, LastSample: c.LastSample})
+		}
+		c.Stale = isStale
+	}
+	s.mu.Unlock()
+
+	for _, c := range stale {
+		s.emitLifecycleEvent(lifecycleEvent{Time: now, Container: c.ID, Event: hookDisappear})
+	}
+	return stale
+}
+
+// watchStale checks for stale containers every second. The stale hook runs
+// with the other hooks on the disappear event, if enabled an error is sent on
+// errc to exit.
+func (s *stats) watchStale(errc chan<- error) {
+	for range time.Tick(time.Second) {
+		var stale []staleContainer
+		if !s.running(func() { stale = s.checkStale(s.now(), staleIntervals) }) {
+			return
+		}
+		for _, c := range stale {
+			logrus.Warnf("container %s is stale, no sample since %s", c.ID, c.LastSample.Format(time.RFC3339))
+
+			if staleExit {
+				// Only the first error is needed to exit.
+				select {
+				case errc <- fmt.Errorf("container %s is stale, no sample since %s", c.ID, c.LastSample.Format(time.RFC3339)):
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+func TestCheckStale(t *testing.T) {
+	start := time.Unix(1000, 0)
+	s := newCaptureStats()
+	var disappeared []string
+	s.lifecycleListeners = append(s.lifecycleListeners, func(e lifecycleEvent) {
+		if e.Event == hookDisappear {
+			disappeared = append(disappeared, e.Container)
+		}
+	})
+	sample := func(id string, at time.Duration) {
+		s.process(captureRecord{Time: start.Add(at), Event: event{Type: "stats", ID: id, Data: types.Stats{}}})
+	}
+
+	// Samples every second.
+	sample("abc", 0)
+	sample("abc", time.Second)
+	sample("def", 0)
+	sample("def", time.Second)
+
+	if stale := s.checkStale(start.Add(3*time.Second), 3); len(stale) != 0 {
+		t.Fatalf("expected no stale container, got %+v", stale)
+	}
+
+	// The runtime reports that def stopped.
+	s.process(captureRecord{Time: start.Add(3 * time.Second), Event: event{Type: "stop", ID: "def"}})
+	if !s.containers["def"].Stale {
+		t.Fatal("expected a stopped container to be stale right away")
+	}
+	s.process(captureRecord{Time: start.Add(3 * time.Second), Event: event{Type: "stop", ID: "def"}})
+
+	stale := s.checkStale(start.Add(5*time.Second), 3)
+	if len(stale) != 1 || stale[0].ID != "abc" {
+		t.Fatalf("expected abc to become stale, got %+v", stale)
+	}
+	if !s.containers["def"].Stale {
+		t.Fatal("expected a stopped container to stay stale")
+	}
+	if want := []string{"def", "abc"}; len(disappeared) != 2 || disappeared[0] != want[0] || disappeared[1] != want[1] {
+		t.Fatalf("expected the disappear events of %v, got %v", want, disappeared)
+	}
+
+	// A new sample brings the container back.
+	sample("def", 6*time.Second)
+	if s.containers["def"].Stale {
+		t.Fatal("expected a container with a new sample not to be stale")
+	}
+	if stale := s.checkStale(start.Add(7*time.Second), 3); len(stale) != 0 {
+		t.Fatalf("expected no new stale container, got %+v", stale)
+	}
+}
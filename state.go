@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// stateFilename is the name of the file libcontainer saves the state of a
+// container in, inside the directory named after the container id.
+const stateFilename = "state.json"
+
+// loadState reads the libcontainer state of the container with the given id
+// from the runc root directory.
+func loadState(root, id string) (*types.State, error) {
+	f, err := os.Open(filepath.Join(root, id, stateFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var state types.State
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// hugetlbLimits returns the hugetlb limits by page size from the cgroup
+// configuration in the container state.
+func hugetlbLimits(state *types.State) map[string]uint64 {
+	limits := map[string]uint64{}
+	if state == nil || state.Config.Cgroups == nil || state.Config.Cgroups.Resources == nil {
+		return limits
+	}
+	for _, l := range state.Config.Cgroups.HugetlbLimit {
+		if l == nil {
+			continue
+		}
+		limits[l.Pagesize] = l.Limit
+	}
+	return limits
+}
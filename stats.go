@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	mstats "github.com/genuinetools/magneto/stats"
+	"github.com/genuinetools/magneto/types"
+	"github.com/sirupsen/logrus"
+)
+
+// stats holds the statistics for every container seen in the events stream.
+type stats struct {
+	mu         sync.RWMutex
+	containers map[string]*containerStats
+	systemCPU  *mstats.SystemCPUReader
+	recorder   *captureWriter
+	// malformed is the number of events that were skipped since they could
+	// not be decoded.
+	malformed uint64
+	// ended is when the events stream ended, zero while it is running.
+	ended time.Time
+	// updated receives a value when a sample was processed, without blocking
+	// if the previous one was not received yet.
+	updated chan struct{}
+	// ruleListeners are called with the rule events, outside of the lock.
+	ruleListeners []func(ruleEvent)
+	// lifecycleListeners are called with the lifecycle events, outside of
+	// the lock.
+	lifecycleListeners []func(lifecycleEvent)
+	// closers are called before exiting.
+	closers      []func()
+	shutdownOnce sync.Once
+	// stopMu is held for reading while an event is processed, stopped is
+	// set once the sources were closed so no more events are processed.
+	stopMu  sync.RWMutex
+	stopped bool
+	// now returns the current time, the time of the replayed event when
+	// replaying a capture.
+	now func() time.Time
+	// captured is set when processing the records of a capture, so the
+	// container states, names and resctrl data of this host, which may not
+	// be where the capture was made, are not read.
+	captured bool
+	// replaying is set while a backward seek processes the records of a
+	// capture again, so the events already sent to the listeners and the
+	// recorder are not sent again.
+	replaying bool
+	// showSources adds the source of the containers to the table, set when
+	// reading from more than one source.
+	showSources bool
+	// ignored are the containers that do not match the filters.
+	ignored map[string]bool
+	// sourceErrs are the errors of the sources that failed.
+	sourceErrs []error
+	err        error
+}
+
+type containerStats struct {
+	ID               string             `json:"id"`
+	Name             string             `json:"name,omitempty"`
+	Labels           map[string]string  `json:"labels,omitempty"`
+	Source           string             `json:"source,omitempty"`
+	CPUPercentage    float64            `json:"cpu_percentage"`
+	ThrottledRatio   float64            `json:"throttled_ratio"`
+	Memory           float64            `json:"memory"`
+	MemoryLimit      float64            `json:"memory_limit"`
+	MemoryPercentage float64            `json:"memory_percentage"`
+	NetworkRx        float64            `json:"network_rx"`
+	NetworkTx        float64            `json:"network_tx"`
+	BlockRead        float64            `json:"block_read"`
+	BlockWrite       float64            `json:"block_write"`
+	PidsCurrent      uint64             `json:"pids_current"`
+	PidsLimit        uint64             `json:"pids_limit"`
+	PidsPercentage   float64            `json:"pids_percentage"`
+	PidsTimeToLimit  time.Duration      `json:"pids_time_to_limit"`
+	BlockIODetail    blkioDetail        `json:"block_io_detail"`
+	Hugetlb          []hugetlbStats     `json:"hugetlb,omitempty"`
+	IntelRdt         intelRdtStats      `json:"intel_rdt"`
+	Rates            mstats.IORates     `json:"rates"`
+	LastSample       time.Time          `json:"last_sample"`
+	Stale            bool               `json:"stale"`
+	Alerts           []string           `json:"alerts,omitempty"`
+	OOMKills         uint64             `json:"oom_kills"`
+	previousSample   time.Time          // time of the sample before the last one
+	stopped          bool               // the runtime reported that the container stopped
+	calc             *mstats.Calculator // previous cpu usage and I/O counters
+	blkio            *blkioTracker      // previous blkio device times
+	pids             *pidsTracker       // recent pids samples
+	state            *types.State       // libcontainer state, nil if it could not be read
+	history          sampleHistory      // samples for the end of session summary
+	rules            []ruleState        // state of the rules, in the order of the rules
+}
+
+func newStats() *stats {
+	return &stats{
+		containers: map[string]*containerStats{},
+		ignored:    map[string]bool{},
+		systemCPU:  mstats.NewSystemCPUReader(),
+		now:        time.Now,
+		updated:    make(chan struct{}, 1),
+	}
+}
+
+// newCaptureStats returns the statistics for processing the records of a
+// capture, without reading anything about the containers from this host.
+func newCaptureStats() *stats {
+	s := newStats()
+	s.captured = true
+	return s
+}
+
+// container returns the statistics for the container with the given id,
+// creating them if this is the first sample for the container.
+// It must be called with the lock held.
+func (s *stats) container(id string) *containerStats {
+	c, ok := s.containers[id]
+	if !ok {
+		c = &containerStats{
+			ID:    id,
+			calc:  mstats.NewCalculator(),
+			blkio: &blkioTracker{},
+			pids:  &pidsTracker{},
+		}
+		if !s.captured {
+			state, err := loadState(root, id)
+			if err != nil {
+				logrus.Debugf("reading state for container %s failed: %v", id, err)
+			}
+			c.state = state
+			c.Name, c.Labels = resolveName(id, state)
+		}
+		s.containers[id] = c
+	}
+	return c
+}
+
+// sorted returns the containers ordered by their id.
+// It must be called with the lock held.
+func (s *stats) sorted() []*containerStats {
+	containers := make([]*containerStats, 0, len(s.containers))
+	for _, c := range s.containers {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+	return containers
+}
+
+// collect reads the events from the source until it ends. Malformed events
+// are skipped and counted. It returns nil when the source ended and the read
+// error otherwise.
+func (s *stats) collect(src Source) error {
+	var malformed uint64
+	for {
+		e, err := src.Read()
+
+		s.mu.Lock()
+		s.malformed += src.Malformed() - malformed
+		s.mu.Unlock()
+		malformed = src.Malformed()
+
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		rec := captureRecord{
+			Time:   time.Now(),
+			Source: src.Name(),
+			Event:  e,
+		}
+
+		if e.Type == "stats" {
+			systemUsage := e.systemUsage
+			if systemUsage == 0 {
+				if systemUsage, err = s.systemCPU.Read(); err != nil {
+					s.setError(fmt.Errorf("collecting system cpu usage failed: %v", err))
+					continue
+				}
+			}
+			rec.SystemCPUUsage = systemUsage
+		}
+
+		if !s.running(func() {
+			s.setError(nil)
+			s.process(rec)
+		}) {
+			return nil
+		}
+	}
+}
+
+// running calls f unless the statistics were stopped, and returns whether it
+// was called.
+func (s *stats) running(f func()) bool {
+	s.stopMu.RLock()
+	defer s.stopMu.RUnlock()
+
+	if s.stopped {
+		return false
+	}
+	f()
+	return true
+}
+
+// stop stops processing events, waiting for the events being processed, so
+// the listeners and the closers do not race with the sources still running.
+func (s *stats) stop() {
+	s.stopMu.Lock()
+	s.stopped = true
+	s.stopMu.Unlock()
+}
+
+// process updates the statistics of the container of the event and records
+// the event. The system cpu usage is taken from the record so recorded events
+// are calculated the same way as when they were received.
+func (s *stats) process(r captureRecord) {
+	// The event is recorded once its container is resolved, with the name
+	// and the labels found on this host, which are not read when replaying.
+	defer func() { s.record(r) }()
+
+	switch r.Event.Type {
+	case "stats":
+	case "oom":
+		s.processOOM(r)
+		return
+	case "stop":
+		s.processStop(r)
+		return
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	if s.ignored[r.Event.ID] {
+		s.mu.Unlock()
+		return
+	}
+	previous, seen := s.containers[r.Event.ID]
+	appeared := !seen || previous.Stale
+	c := s.container(r.Event.ID)
+	c.Source = r.Source
+	if r.Event.Name != "" {
+		c.Name = r.Event.Name
+	}
+	if r.Event.Labels != nil {
+		c.Labels = r.Event.Labels
+	}
+	r.Event.Name, r.Event.Labels = c.Name, c.Labels
+	if !seen && !c.matchesFilters(filters) {
+		delete(s.containers, r.Event.ID)
+		s.ignored[r.Event.ID] = true
+		s.mu.Unlock()
+		return
+	}
+	c.update(r.Event.Data, r.SystemCPUUsage, r.Time)
+	events := c.evaluateRules(r.Time)
+	s.mu.Unlock()
+
+	if appeared {
+		s.emitLifecycleEvent(lifecycleEvent{Time: r.Time, Container: r.Event.ID, Event: hookAppear})
+	}
+	for _, e := range events {
+		s.emitRuleEvent(e)
+	}
+
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+// processOOM counts the OOM kill for its container and emits it as a firing
+// event of the oom rule.
+func (s *stats) processOOM(r captureRecord) {
+	s.mu.Lock()
+	if s.ignored[r.Event.ID] {
+		s.mu.Unlock()
+		return
+	}
+	var (
+		name          string
+		memory, limit float64
+	)
+	if c, ok := s.containers[r.Event.ID]; ok {
+		c.OOMKills++
+		name, memory, limit = c.Name, c.Memory, c.MemoryLimit
+	}
+	s.mu.Unlock()
+
+	s.emitRuleEvent(ruleEvent{
+		Time:      r.Time,
+		Container: r.Event.ID,
+		Name:      name,
+		Rule:      ruleOOM,
+		State:     ruleFiring,
+		Previous:  ruleInactive,
+		Value:     memory,
+		Threshold: limit,
+	})
+}
+
+// processStop marks the container as stale right away when its runtime
+// reported that it stopped or was paused, instead of waiting for it to miss
+// its samples, and emits its disappear event.
+func (s *stats) processStop(r captureRecord) {
+	s.mu.Lock()
+	c, ok := s.containers[r.Event.ID]
+	if !ok || c.Stale {
+		s.mu.Unlock()
+		return
+	}
+	c.Stale = true
+	c.stopped = true
+	s.mu.Unlock()
+
+	s.emitLifecycleEvent(lifecycleEvent{Time: r.Time, Container: r.Event.ID, Event: hookDisappear})
+}
+
+// reset drops the statistics of all the containers.
+func (s *stats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers = map[string]*containerStats{}
+	s.ignored = map[string]bool{}
+	s.sourceErrs = nil
+	s.err = nil
+}
+
+// record writes the event to the capture file if recording is enabled.
+func (s *stats) record(r captureRecord) {
+	if s.recorder == nil || s.replaying {
+		return
+	}
+	if err := s.recorder.Write(r); err != nil {
+		logrus.Errorf("recording event failed: %v", err)
+	}
+}
+
+// update calculates the container statistics from the sample v read at now.
+func (c *containerStats) update(v types.Stats, systemUsage uint64, now time.Time) {
+	r := c.calc.AddSample(v, systemUsage, now)
+	c.CPUPercentage = r.CPUPercentage
+	c.ThrottledRatio = r.ThrottledRatio
+
+	c.BlockRead = float64(r.Counters.BlockRead)
+	c.BlockWrite = float64(r.Counters.BlockWrite)
+	c.NetworkRx = float64(r.Counters.NetworkRx)
+	c.NetworkTx = float64(r.Counters.NetworkTx)
+	c.Rates = r.Rates
+	c.BlockIODetail = c.blkio.calculateBlockIODetail(v.Blkio, now)
+
+	c.Memory = r.Memory
+	c.MemoryLimit = r.MemoryLimit
+	c.MemoryPercentage = r.MemoryPercentage
+
+	c.Hugetlb = calculateHugetlb(v.Hugetlb, hugetlbLimits(c.state), c.Hugetlb)
+	c.IntelRdt = calculateIntelRdt(v.IntelRdt, c.state, c.IntelRdt, now)
+
+	c.PidsCurrent = r.PidsCurrent
+	c.PidsLimit = r.PidsLimit
+	c.PidsPercentage = r.PidsPercentage
+	c.PidsTimeToLimit = c.pids.calculateTimeToLimit(c.PidsCurrent, c.PidsLimit, now)
+	c.previousSample = c.LastSample
+	c.LastSample = now
+	c.Stale = false
+	c.stopped = false
+
+	c.history.add(c, now)
+}
+
+// Display writes a row in the table for every group, with the rows of its
+// containers beneath if it is expanded, and for every container not in a
+// group.
+func (s *stats) Display(w io.Writer, v *groupView) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	groups := s.groups()
+	v.setGroups(groups)
+
+	// Leave room for the cursor on the selected group.
+	indent := ""
+	if v.interactive {
+		indent = "  "
+	}
+	for _, g := range groups {
+		cursor := indent
+		if v.interactive && g.name != "" && g.name == v.selected {
+			cursor = "> "
+		}
+
+		if g.name == "" {
+			s.displayRow(w, cursor+g.containers[0].displayName(), g.containers[0])
+			continue
+		}
+
+		expanded := v.isExpanded(g.name)
+		marker := "[+]"
+		if expanded {
+			marker = "[-]"
+		}
+		s.displayRow(w, fmt.Sprintf("%s%s %s (%d)", cursor, marker, g.name, len(g.containers)), g.total())
+		if !expanded {
+			continue
+		}
+		for _, c := range g.containers {
+			s.displayRow(w, indent+"    "+c.displayName(), c)
+		}
+	}
+}
+
+// displayRow writes the row of a container in the table under the name.
+func (s *stats) displayRow(w io.Writer, name string, c *containerStats) {
+	var netIO, blockIO string
+	if totals {
+		netIO = fmt.Sprintf("%s / %s",
+			units.HumanSizeWithPrecision(c.NetworkRx, 3), units.HumanSizeWithPrecision(c.NetworkTx, 3))
+		blockIO = fmt.Sprintf("%s / %s",
+			units.HumanSizeWithPrecision(c.BlockRead, 3), units.HumanSizeWithPrecision(c.BlockWrite, 3))
+	} else {
+		netIO = fmt.Sprintf("%s/s / %s/s",
+			units.HumanSizeWithPrecision(c.Rates.NetworkRx, 3), units.HumanSizeWithPrecision(c.Rates.NetworkTx, 3))
+		blockIO = fmt.Sprintf("%s/s / %s/s",
+			units.HumanSizeWithPrecision(c.Rates.BlockRead, 3), units.HumanSizeWithPrecision(c.Rates.BlockWrite, 3))
+	}
+
+	if c.Stale {
+		name = name + " (stale)"
+	}
+
+	if s.showSources {
+		fmt.Fprintf(w, "%s\t", c.Source)
+	}
+	fmt.Fprintf(w, "%s\t%.2f%%\t%s / %s\t%.2f%%\t%s\t%s\t%s\n",
+		name,
+		c.CPUPercentage,
+		units.BytesSize(c.Memory), units.BytesSize(c.MemoryLimit),
+		c.MemoryPercentage,
+		netIO,
+		blockIO,
+		formatPids(c.PidsCurrent, c.PidsLimit, c.PidsPercentage, c.PidsTimeToLimit))
+}
+
+// DisplayStatus writes the state of the events stream below the table, if
+// there is anything to report.
+func (s *stats) DisplayStatus(w io.Writer) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.err != nil {
+		fmt.Fprintf(w, "\n%serror: %v%s\n", colorRed, s.err, colorReset)
+	}
+	for _, err := range s.sourceErrs {
+		fmt.Fprintf(w, "\n%serror: %v%s\n", colorRed, err, colorReset)
+	}
+	if s.malformed > 0 {
+		fmt.Fprintf(w, "\nskipped %d malformed events\n", s.malformed)
+	}
+	if !s.ended.IsZero() {
+		fmt.Fprintf(w, "\n%sevents stream ended at %s, the values are stale%s\n",
+			colorYellow, s.ended.Format("15:04:05"), colorReset)
+	}
+}
+
+// DisplayDetail writes the detailed statistics that do not fit in the main
+// table.
+func (s *stats) DisplayDetail(w io.Writer) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	io.WriteString(w, "CONTAINER\tCPU THROTTLED %\tNET PACKETS/S\tBLOCK OPS/S\tBLOCK SVC LATENCY\tBLOCK WAIT LATENCY\tBLOCK QUEUE\tBLOCK MERGED %\tBLOCK UTIL %\tOOM KILLS\n")
+	for _, c := range s.sorted() {
+		d := c.BlockIODetail
+		fmt.Fprintf(w, "%s\t%.2f%%\t%.1f / %.1f\t%.1f / %.1f\t%s\t%s\t%d\t%.2f%%\t%.2f%%\t%d\n",
+			c.displayName(),
+			c.ThrottledRatio*100.0,
+			c.Rates.NetworkRxPackets, c.Rates.NetworkTxPackets,
+			c.Rates.BlockReadOps, c.Rates.BlockWriteOps,
+			time.Duration(d.ServiceLatency), time.Duration(d.WaitLatency),
+			d.QueueDepth, d.MergePercentage, d.Utilization,
+			c.OOMKills)
+	}
+}
+
+// DisplayJSON writes the statistics for every container as a line of JSON.
+func (s *stats) DisplayJSON(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	for _, c := range s.sorted() {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setError sets container statistics error
+func (s *stats) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// addSourceError records the error of a source that failed.
+func (s *stats) addSourceError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sourceErrs = append(s.sourceErrs, err)
+}
+
+// setEnded marks the events stream as ended.
+func (s *stats) setEnded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = time.Now()
+}
@@ -0,0 +1,96 @@
+// Package stats calculates the statistics of a container from the samples of
+// the runc events stream, the same way as docker stats.
+package stats
+
+import (
+	"strings"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// CPUPercent returns the cpu usage of the container between two samples as a
+// percentage of one cpu, so a container using two cpus fully is at 200%.
+// The previous values are the container and host system cpu usage of the
+// previous sample.
+func CPUPercent(previousCPU, previousSystem, systemUsage uint64, v types.Stats) float64 {
+	var (
+		cpuPercent = 0.0
+		// calculate the change for the cpu usage of the container in between readings
+		cpuDelta = float64(v.CPU.Usage.Total) - float64(previousCPU)
+		// calculate the change for the entire system between readings
+		systemDelta = float64(systemUsage) - float64(previousSystem)
+	)
+
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		cpuPercent = (cpuDelta / systemDelta) * float64(len(v.CPU.Usage.Percpu)) * 100.0
+	}
+	return cpuPercent
+}
+
+// ThrottledRatio returns the ratio of the cpu periods between two samples in
+// which the container was throttled.
+func ThrottledRatio(previous, current types.Throttling) float64 {
+	periods := CounterDelta(current.Periods, previous.Periods)
+	if periods > 0 {
+		return CounterDelta(current.ThrottledPeriods, previous.ThrottledPeriods) / periods
+	}
+	return 0
+}
+
+// BlockIO returns the bytes read and written by the container.
+func BlockIO(blkio types.Blkio) (uint64, uint64) {
+	return sumBlkioOps(blkio.IoServiceBytesRecursive)
+}
+
+// BlockIOOps returns the read and write operations of the container.
+func BlockIOOps(blkio types.Blkio) (uint64, uint64) {
+	return sumBlkioOps(blkio.IoServicedRecursive)
+}
+
+func sumBlkioOps(entries []types.BlkioEntry) (uint64, uint64) {
+	var read, write uint64
+	for _, bioEntry := range entries {
+		switch strings.ToLower(bioEntry.Op) {
+		case "read":
+			read = read + bioEntry.Value
+		case "write":
+			write = write + bioEntry.Value
+		}
+	}
+	return read, write
+}
+
+// MemUsageNoCache calculate memory usage of the container.
+// Page cache is intentionally excluded to avoid misinterpretation of the output.
+func MemUsageNoCache(mem types.Memory) float64 {
+	return float64(mem.Usage.Usage - mem.Cache)
+}
+
+// MemPercentNoCache returns the memory usage as a percentage of the limit.
+func MemPercentNoCache(limit float64, usedNoCache float64) float64 {
+	// MemoryStats.Limit will never be 0 unless the container is not running and we haven't
+	// got any data from cgroup
+	if limit != 0 {
+		return usedNoCache / limit * 100.0
+	}
+	return 0
+}
+
+// PidsPercent returns the number of pids as a percentage of the limit, 0 if
+// there is no limit.
+func PidsPercent(current, limit uint64) float64 {
+	if limit == 0 {
+		return 0
+	}
+	return float64(current) / float64(limit) * 100.0
+}
+
+// CounterDelta returns the increase of a cumulative counter. If the counter
+// went backwards it was reset, for example by a container restart, so the
+// current value is the increase since the reset.
+func CounterDelta(current, previous uint64) float64 {
+	if current < previous {
+		return float64(current)
+	}
+	return float64(current - previous)
+}
@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// Result holds the statistics of a container calculated from a sample.
+type Result struct {
+	CPUPercentage    float64 `json:"cpu_percentage"`
+	ThrottledRatio   float64 `json:"throttled_ratio"`
+	Memory           float64 `json:"memory"`
+	MemoryLimit      float64 `json:"memory_limit"`
+	MemoryPercentage float64 `json:"memory_percentage"`
+	PidsCurrent      uint64  `json:"pids_current"`
+	PidsLimit        uint64  `json:"pids_limit"`
+	PidsPercentage   float64 `json:"pids_percentage"`
+	// Counters are the cumulative I/O counters since the container started.
+	Counters IOCounters `json:"counters"`
+	// Rates are the per second I/O rates since the previous sample, zero for
+	// the first sample.
+	Rates IORates `json:"rates"`
+}
+
+// IOCounters holds the cumulative network and block I/O counters of a
+// container since it was started.
+type IOCounters struct {
+	NetworkRx        uint64 `json:"network_rx"`
+	NetworkTx        uint64 `json:"network_tx"`
+	NetworkRxPackets uint64 `json:"network_rx_packets"`
+	NetworkTxPackets uint64 `json:"network_tx_packets"`
+	BlockRead        uint64 `json:"block_read"`
+	BlockWrite       uint64 `json:"block_write"`
+	BlockReadOps     uint64 `json:"block_read_ops"`
+	BlockWriteOps    uint64 `json:"block_write_ops"`
+}
+
+// IORates holds the per second network and block I/O rates of a container.
+type IORates struct {
+	NetworkRx        float64 `json:"network_rx"`
+	NetworkTx        float64 `json:"network_tx"`
+	NetworkRxPackets float64 `json:"network_rx_packets"`
+	NetworkTxPackets float64 `json:"network_tx_packets"`
+	BlockRead        float64 `json:"block_read"`
+	BlockWrite       float64 `json:"block_write"`
+	BlockReadOps     float64 `json:"block_read_ops"`
+	BlockWriteOps    float64 `json:"block_write_ops"`
+}
+
+// Counters sums the network and block I/O counters of a sample.
+func Counters(v types.Stats) IOCounters {
+	var c IOCounters
+	for _, iface := range v.NetworkInterfaces {
+		if iface == nil {
+			continue
+		}
+		c.NetworkRx = c.NetworkRx + iface.RxBytes
+		c.NetworkTx = c.NetworkTx + iface.TxBytes
+		c.NetworkRxPackets = c.NetworkRxPackets + iface.RxPackets
+		c.NetworkTxPackets = c.NetworkTxPackets + iface.TxPackets
+	}
+	c.BlockRead, c.BlockWrite = BlockIO(v.Blkio)
+	c.BlockReadOps, c.BlockWriteOps = BlockIOOps(v.Blkio)
+	return c
+}
+
+// Calculator calculates the statistics of a container from its successive
+// samples. It keeps the values of the previous sample, so a Calculator must
+// be used for a single container.
+type Calculator struct {
+	// Now returns the time a sample is read at, time.Now by default.
+	Now func() time.Time
+	// SystemCPUUsage returns the host system cpu usage in nanoseconds, read
+	// from /proc/stat by default.
+	SystemCPUUsage func() (uint64, error)
+
+	previousCPU      uint64
+	previousSystem   uint64
+	previousThrottle types.Throttling
+	previousCounters IOCounters
+	previousRead     time.Time
+}
+
+// NewCalculator returns a Calculator reading the time from the clock and the
+// host system cpu usage from /proc/stat.
+func NewCalculator() *Calculator {
+	return &Calculator{
+		Now:            time.Now,
+		SystemCPUUsage: NewSystemCPUReader().Read,
+	}
+}
+
+// Add reads the current time and host system cpu usage and returns the
+// statistics of the sample.
+func (c *Calculator) Add(v types.Stats) (Result, error) {
+	systemUsage, err := c.SystemCPUUsage()
+	if err != nil {
+		return Result{}, err
+	}
+	return c.AddSample(v, systemUsage, c.Now()), nil
+}
+
+// AddSample returns the statistics of the sample read at now, when the host
+// system cpu usage was systemUsage. It is used to calculate samples that
+// were recorded along with the host system cpu usage.
+func (c *Calculator) AddSample(v types.Stats, systemUsage uint64, now time.Time) Result {
+	r := Result{
+		CPUPercentage:  CPUPercent(c.previousCPU, c.previousSystem, systemUsage, v),
+		ThrottledRatio: ThrottledRatio(c.previousThrottle, v.CPU.Throttling),
+		Memory:         MemUsageNoCache(v.Memory),
+		MemoryLimit:    float64(v.Memory.Usage.Limit),
+		PidsCurrent:    v.Pids.Current,
+		PidsLimit:      v.Pids.Limit,
+		PidsPercentage: PidsPercent(v.Pids.Current, v.Pids.Limit),
+		Counters:       Counters(v),
+	}
+	r.MemoryPercentage = MemPercentNoCache(r.MemoryLimit, r.Memory)
+	r.Rates = c.rates(r.Counters, now)
+
+	c.previousCPU = v.CPU.Usage.Total
+	c.previousSystem = systemUsage
+	c.previousThrottle = v.CPU.Throttling
+	return r
+}
+
+// rates returns the per second rates between the previous sample and the
+// counters read at now. The elapsed time is taken from the monotonic clock
+// reading of the timestamps so wall clock changes do not skew it.
+func (c *Calculator) rates(counters IOCounters, now time.Time) IORates {
+	var (
+		r       IORates
+		p       = c.previousCounters
+		elapsed = now.Sub(c.previousRead).Seconds()
+	)
+
+	if !c.previousRead.IsZero() && elapsed > 0 {
+		r = IORates{
+			NetworkRx:        CounterDelta(counters.NetworkRx, p.NetworkRx) / elapsed,
+			NetworkTx:        CounterDelta(counters.NetworkTx, p.NetworkTx) / elapsed,
+			NetworkRxPackets: CounterDelta(counters.NetworkRxPackets, p.NetworkRxPackets) / elapsed,
+			NetworkTxPackets: CounterDelta(counters.NetworkTxPackets, p.NetworkTxPackets) / elapsed,
+			BlockRead:        CounterDelta(counters.BlockRead, p.BlockRead) / elapsed,
+			BlockWrite:       CounterDelta(counters.BlockWrite, p.BlockWrite) / elapsed,
+			BlockReadOps:     CounterDelta(counters.BlockReadOps, p.BlockReadOps) / elapsed,
+			BlockWriteOps:    CounterDelta(counters.BlockWriteOps, p.BlockWriteOps) / elapsed,
+		}
+	}
+
+	c.previousCounters = counters
+	c.previousRead = now
+	return r
+}
@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/genuinetools/magneto/types"
+)
+
+// sample returns a sample with the cpu usage of two cpus, the throttling and
+// the network and block I/O counters.
+func sample(cpu, periods, throttled, rx, read uint64) types.Stats {
+	return types.Stats{
+		CPU: types.CPU{
+			Usage:      types.CPUUsage{Total: cpu, Percpu: []uint64{cpu / 2, cpu - cpu/2}},
+			Throttling: types.Throttling{Periods: periods, ThrottledPeriods: throttled},
+		},
+		Memory: types.Memory{
+			Cache: 100,
+			Usage: types.MemoryEntry{Usage: 1100, Limit: 4000},
+		},
+		Pids: types.Pids{Current: 5, Limit: 20},
+		Blkio: types.Blkio{
+			IoServiceBytesRecursive: []types.BlkioEntry{{Op: "Read", Value: read}, {Op: "Total", Value: read}},
+		},
+		NetworkInterfaces: []*types.NetworkInterface{{Name: "eth0", RxBytes: rx}},
+	}
+}
+
+func TestCalculator(t *testing.T) {
+	start := time.Unix(1000, 0)
+	type step struct {
+		v      types.Stats
+		system uint64
+		at     time.Duration
+	}
+	testCases := []struct {
+		name  string
+		steps []step
+		want  Result
+	}{
+		{
+			name:  "first sample",
+			steps: []step{{sample(1e9, 10, 5, 1000, 2000), 4e9, 0}},
+			want: Result{
+				// The cpu usage since the container started against the
+				// system usage since boot.
+				CPUPercentage:    50,
+				ThrottledRatio:   0.5,
+				Memory:           1000,
+				MemoryLimit:      4000,
+				MemoryPercentage: 25,
+				PidsCurrent:      5,
+				PidsLimit:        20,
+				PidsPercentage:   25,
+				Counters:         IOCounters{NetworkRx: 1000, BlockRead: 2000},
+			},
+		},
+		{
+			name: "second sample",
+			steps: []step{
+				{sample(1e9, 10, 5, 1000, 2000), 4e9, 0},
+				{sample(2e9, 20, 6, 3000, 2500), 8e9, 2 * time.Second},
+			},
+			want: Result{
+				CPUPercentage:    50,
+				ThrottledRatio:   0.1,
+				Memory:           1000,
+				MemoryLimit:      4000,
+				MemoryPercentage: 25,
+				PidsCurrent:      5,
+				PidsLimit:        20,
+				PidsPercentage:   25,
+				Counters:         IOCounters{NetworkRx: 3000, BlockRead: 2500},
+				Rates:            IORates{NetworkRx: 1000, BlockRead: 250},
+			},
+		},
+		{
+			name: "counter reset",
+			steps: []step{
+				{sample(5e9, 100, 50, 10000, 20000), 4e9, 0},
+				// The container restarted, the counters start over.
+				{sample(1e9, 10, 1, 500, 1000), 8e9, time.Second},
+			},
+			want: Result{
+				CPUPercentage:    0,
+				ThrottledRatio:   0.1,
+				Memory:           1000,
+				MemoryLimit:      4000,
+				MemoryPercentage: 25,
+				PidsCurrent:      5,
+				PidsLimit:        20,
+				PidsPercentage:   25,
+				Counters:         IOCounters{NetworkRx: 500, BlockRead: 1000},
+				Rates:            IORates{NetworkRx: 500, BlockRead: 1000},
+			},
+		},
+		{
+			name: "no time elapsed",
+			steps: []step{
+				{sample(1e9, 10, 5, 1000, 2000), 4e9, time.Second},
+				{sample(2e9, 10, 5, 3000, 2500), 8e9, time.Second},
+			},
+			want: Result{
+				CPUPercentage:    50,
+				Memory:           1000,
+				MemoryLimit:      4000,
+				MemoryPercentage: 25,
+				PidsCurrent:      5,
+				PidsLimit:        20,
+				PidsPercentage:   25,
+				Counters:         IOCounters{NetworkRx: 3000, BlockRead: 2500},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				now    time.Time
+				system uint64
+			)
+			c := &Calculator{
+				Now:            func() time.Time { return now },
+				SystemCPUUsage: func() (uint64, error) { return system, nil },
+			}
+
+			var (
+				got Result
+				err error
+			)
+			for _, s := range tc.steps {
+				now, system = start.Add(s.at), s.system
+				if got, err = c.Add(s.v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCalculatorSystemCPUUsageError(t *testing.T) {
+	c := &Calculator{
+		Now:            time.Now,
+		SystemCPUUsage: func() (uint64, error) { return 0, errors.New("no /proc/stat") },
+	}
+	if _, err := c.Add(sample(1e9, 0, 0, 0, 0)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	testCases := []struct {
+		current, previous uint64
+		want              float64
+	}{
+		{10, 4, 6},
+		{4, 4, 0},
+		{3, 10, 3},
+	}
+
+	for _, tc := range testCases {
+		if got := CounterDelta(tc.current, tc.previous); got != tc.want {
+			t.Errorf("expected %v for %d after %d, got %v", tc.want, tc.current, tc.previous, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+const nanoSecondsPerSecond = 1e9
+
+// SystemCPUReader reads the host system cpu usage from /proc/stat.
+type SystemCPUReader struct {
+	// Open opens the /proc/stat file, it can be replaced to read from
+	// somewhere else.
+	Open func() (io.ReadCloser, error)
+	// ClockTicks is the number of clock ticks per second the values in
+	// /proc/stat are counted in.
+	ClockTicks uint64
+
+	// mu guards the buffered reader, which is shared by the concurrent reads.
+	mu        sync.Mutex
+	bufReader *bufio.Reader
+}
+
+// NewSystemCPUReader returns a reader for /proc/stat with the clock ticks of
+// the host.
+func NewSystemCPUReader() *SystemCPUReader {
+	return &SystemCPUReader{
+		Open: func() (io.ReadCloser, error) {
+			return os.Open("/proc/stat")
+		},
+		ClockTicks: uint64(system.GetClockTicks()),
+		bufReader:  bufio.NewReaderSize(nil, 128),
+	}
+}
+
+// Read returns the host system's cpu usage in nanoseconds. An error is
+// returned if the format of the underlying file does not match. It is safe to
+// call from multiple goroutines.
+func (r *SystemCPUReader) Read() (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := r.Open()
+	if err != nil {
+		return 0, err
+	}
+	if r.bufReader == nil {
+		r.bufReader = bufio.NewReaderSize(nil, 128)
+	}
+	defer func() {
+		r.bufReader.Reset(nil)
+		f.Close()
+	}()
+	r.bufReader.Reset(f)
+	return ParseSystemCPUUsage(r.bufReader, r.ClockTicks)
+}
+
+// ParseSystemCPUUsage returns the host system's cpu usage in nanoseconds from
+// the content of /proc/stat.
+//
+// Uses /proc/stat defined by POSIX. Looks for the cpu
+// statistics line and then sums up the first seven fields
+// provided. See `man 5 proc` for details on specific field
+// information.
+func ParseSystemCPUUsage(r io.Reader, clockTicks uint64) (uint64, error) {
+	if clockTicks == 0 {
+		return 0, fmt.Errorf("the number of clock ticks per second must be greater than 0")
+	}
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	var (
+		line string
+		err  error
+	)
+	for err == nil {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			break
+		}
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		switch parts[0] {
+		case "cpu":
+			if len(parts) < 8 {
+				return 0, fmt.Errorf("invalid number of cpu fields")
+			}
+			var totalClockTicks uint64
+			for _, i := range parts[1:8] {
+				v, err := strconv.ParseUint(i, 10, 64)
+				if err != nil {
+					return 0, fmt.Errorf("unable to convert value %s to int: %s", i, err)
+				}
+				totalClockTicks += v
+			}
+			return (totalClockTicks * nanoSecondsPerSecond) /
+				clockTicks, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid stat format. Error trying to parse the '/proc/stat' file")
+}
@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSystemCPUReaderConcurrent(t *testing.T) {
+	r := &SystemCPUReader{
+		Open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("cpu  1 2 3 4 5 6 7 8 9 10\ncpu0 1 2 3 4 5 6 7 8 9 10\n")), nil
+		},
+		ClockTicks: 100,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				v, err := r.Read()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if v != 280000000 {
+					t.Errorf("expected 280000000, got %d", v)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestParseSystemCPUUsage(t *testing.T) {
+	testCases := []struct {
+		name       string
+		stat       string
+		clockTicks uint64
+		want       uint64
+		wantErr    bool
+	}{
+		{
+			name:       "sums the first seven fields",
+			stat:       "cpu  1 2 3 4 5 6 7 8 9 10\ncpu0 1 2 3 4 5 6 7 8 9 10\nintr 1\n",
+			clockTicks: 100,
+			want:       280000000,
+		},
+		{
+			name:       "cpu line after others",
+			stat:       "\nintr 1 2\ncpu  100 0 0 0 0 0 0\n",
+			clockTicks: 100,
+			want:       1000000000,
+		},
+		{name: "too few fields", stat: "cpu  1 2 3\n", clockTicks: 100, wantErr: true},
+		{name: "invalid value", stat: "cpu  1 2 x 4 5 6 7\n", clockTicks: 100, wantErr: true},
+		{name: "no cpu line", stat: "cpu0 1 2 3 4 5 6 7\n", clockTicks: 100, wantErr: true},
+		{name: "no clock ticks", stat: "cpu  1 2 3 4 5 6 7\n", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSystemCPUUsage(strings.NewReader(tc.stat), tc.clockTicks)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
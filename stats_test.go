@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessRecordsResolvedName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magneto-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A docker container without a runc state.
+	defer func(r, d string) { root, dockerRoot = r, d }(root, dockerRoot)
+	root, dockerRoot = filepath.Join(dir, "runc"), filepath.Join(dir, "docker")
+	if err := os.MkdirAll(filepath.Join(dockerRoot, "containers", "abc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"Name": "/db", "Config": {"Labels": {"tier": "data"}}}`
+	if err := ioutil.WriteFile(filepath.Join(dockerRoot, "containers", "abc", "config.v2.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	capture := filepath.Join(dir, "capture.jsonl")
+	s := newStats()
+	if s.recorder, err = newCaptureWriter(capture, 100); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	s.process(captureRecord{Time: now, Source: "stdin", Event: event{Type: "stats", ID: "abc"}})
+	s.process(captureRecord{Time: now, Source: "stdin", Event: event{Type: "oom", ID: "abc"}})
+	if err := s.recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, records, err := readCapture(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if e := records[0].Event; e.Name != "db" || e.Labels["tier"] != "data" {
+		t.Fatalf("expected the resolved name and labels in the capture, got %+v", e)
+	}
+	if e := records[1].Event; e.Type != "oom" {
+		t.Fatalf("expected the oom event to be recorded, got %+v", e)
+	}
+}
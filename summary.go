@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// summaryMetric describes a metric kept for the end of session summary.
+type summaryMetric struct {
+	name   string
+	header string
+	format func(float64) string
+}
+
+func formatPercentage(v float64) string { return fmt.Sprintf("%.2f%%", v) }
+func formatBytes(v float64) string      { return units.BytesSize(v) }
+func formatRate(v float64) string       { return units.HumanSizeWithPrecision(v, 3) + "/s" }
+func formatCount(v float64) string      { return fmt.Sprintf("%.0f", v) }
+
+// summaryMetrics are the metrics in the summary, in the order they are shown.
+var summaryMetrics = []summaryMetric{
+	{name: "cpu_percentage", header: "CPU %", format: formatPercentage},
+	{name: "memory", header: "MEM USAGE", format: formatBytes},
+	{name: "memory_percentage", header: "MEM %", format: formatPercentage},
+	{name: "network_rx", header: "NET RX", format: formatRate},
+	{name: "network_tx", header: "NET TX", format: formatRate},
+	{name: "block_read", header: "BLOCK READ", format: formatRate},
+	{name: "block_write", header: "BLOCK WRITE", format: formatRate},
+	{name: "pids", header: "PIDS", format: formatCount},
+}
+
+const (
+	// historyReservoirSize is the number of values of a metric kept for the
+	// percentiles, so long sessions use a bounded amount of memory.
+	historyReservoirSize = 10000
+)
+
+// metricHistory keeps the exact minimum, maximum and sum of the values of a
+// metric, and a uniform random sample of at most historyReservoirSize
+// values for the percentiles.
+type metricHistory struct {
+	count     int
+	sum       float64
+	min       float64
+	max       float64
+	reservoir []float64
+}
+
+// add adds a value, replacing a random value of the reservoir once it is
+// full so every value has the same chance to be in it.
+func (m *metricHistory) add(v float64, rnd *rand.Rand) {
+	if m.count == 0 || v < m.min {
+		m.min = v
+	}
+	if m.count == 0 || v > m.max {
+		m.max = v
+	}
+	m.count++
+	m.sum += v
+
+	if len(m.reservoir) < historyReservoirSize {
+		m.reservoir = append(m.reservoir, v)
+		return
+	}
+	if i := rnd.Intn(m.count); i < historyReservoirSize {
+		m.reservoir[i] = v
+	}
+}
+
+// sampleHistory keeps the values of the summary metrics of a container.
+type sampleHistory struct {
+	samples int
+	first   time.Time
+	last    time.Time
+	metrics map[string]*metricHistory
+	// rnd picks the values replaced in the reservoirs, seeded the same for
+	// every container so replays give the same summary.
+	rnd *rand.Rand
+}
+
+// values returns the values of the metric kept in the reservoir, all of them
+// unless there were more than historyReservoirSize.
+func (h *sampleHistory) values(metric string) []float64 {
+	if m, ok := h.metrics[metric]; ok {
+		return m.reservoir
+	}
+	return nil
+}
+
+func (h *sampleHistory) addValue(metric string, v float64) {
+	m, ok := h.metrics[metric]
+	if !ok {
+		m = &metricHistory{}
+		h.metrics[metric] = m
+	}
+	m.add(v, h.rnd)
+}
+
+// add adds the values of a sample. The cpu percentage and rates are
+// calculated against the previous sample, so they are skipped for the first
+// one.
+func (h *sampleHistory) add(c *containerStats, now time.Time) {
+	if h.metrics == nil {
+		h.metrics = map[string]*metricHistory{}
+		h.rnd = rand.New(rand.NewSource(1))
+		h.first = now
+	}
+	h.samples++
+	h.last = now
+
+	h.addValue("memory", c.Memory)
+	h.addValue("memory_percentage", c.MemoryPercentage)
+	h.addValue("pids", float64(c.PidsCurrent))
+
+	if h.samples < 2 {
+		return
+	}
+	h.addValue("cpu_percentage", c.CPUPercentage)
+	h.addValue("network_rx", c.Rates.NetworkRx)
+	h.addValue("network_tx", c.Rates.NetworkTx)
+	h.addValue("block_read", c.Rates.BlockRead)
+	h.addValue("block_write", c.Rates.BlockWrite)
+}
+
+// metricSummary holds the distribution of a metric over a session.
+type metricSummary struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// containerSummary holds the end of session summary of a container.
+type containerSummary struct {
+	ID       string                   `json:"id"`
+	Name     string                   `json:"name,omitempty"`
+	Samples  int                      `json:"samples"`
+	First    time.Time                `json:"first_sample"`
+	Last     time.Time                `json:"last_sample"`
+	Duration float64                  `json:"duration_seconds"`
+	Metrics  map[string]metricSummary `json:"metrics"`
+}
+
+// displayName returns the name of the container, its id if it has none.
+func (c containerSummary) displayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.ID
+}
+
+// summarize returns the distribution of the values of the metric. The
+// minimum, average and maximum are exact, the percentiles are calculated
+// from the reservoir.
+func summarize(m *metricHistory) metricSummary {
+	if m == nil || m.count == 0 {
+		return metricSummary{}
+	}
+
+	sorted := make([]float64, len(m.reservoir))
+	copy(sorted, m.reservoir)
+	sort.Float64s(sorted)
+
+	return metricSummary{
+		Min: m.min,
+		Avg: m.sum / float64(m.count),
+		Max: m.max,
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted values, interpolating
+// linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100.0 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Summary returns the end of session summary of every container.
+func (s *stats) Summary() []containerSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var summaries []containerSummary
+	for _, c := range s.sorted() {
+		h := c.history
+		summary := containerSummary{
+			ID:       c.ID,
+			Name:     c.Name,
+			Samples:  h.samples,
+			First:    h.first,
+			Last:     h.last,
+			Duration: h.last.Sub(h.first).Seconds(),
+			Metrics:  map[string]metricSummary{},
+		}
+		for _, m := range summaryMetrics {
+			if mh, ok := h.metrics[m.name]; ok && mh.count > 0 {
+				summary.Metrics[m.name] = summarize(mh)
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// writeSummary writes the summaries as text tables or JSON.
+func writeSummary(w io.Writer, summaries []containerSummary, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(summaries)
+	}
+
+	tw := tabwriter.NewWriter(w, 12, 1, 3, ' ', 0)
+	for _, summary := range summaries {
+		fmt.Fprintf(tw, "\nCONTAINER %s\tSAMPLES %d\tDURATION %s\n\n",
+			summary.displayName(), summary.Samples, time.Duration(summary.Duration*float64(time.Second)).Round(time.Second))
+		io.WriteString(tw, "METRIC\tMIN\tAVG\tMAX\tP50\tP95\tP99\n")
+		for _, m := range summaryMetrics {
+			ms, ok := summary.Metrics[m.name]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				m.header,
+				m.format(ms.Min), m.format(ms.Avg), m.format(ms.Max),
+				m.format(ms.P50), m.format(ms.P95), m.format(ms.P99))
+		}
+	}
+	return tw.Flush()
+}
+
+// printSummary writes the end of session summary in the summary format, if
+// enabled.
+func printSummary(s *stats) {
+	if summary == "none" {
+		return
+	}
+	// The JSON output is a stream of JSON lines, the summary goes to stderr
+	// so it does not break it.
+	out := os.Stdout
+	if format == "json" {
+		out = os.Stderr
+	}
+	if err := writeSummary(out, s.Summary(), summary); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	testCases := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 50, 0},
+		{"single", []float64{3}, 99, 3},
+		{"median odd", []float64{1, 2, 3}, 50, 2},
+		{"median even", []float64{1, 2, 3, 4}, 50, 2.5},
+		{"interpolated", []float64{0, 10}, 95, 9.5},
+		{"max", []float64{1, 2, 3}, 100, 3},
+		{"min", []float64{1, 2, 3}, 0, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.sorted, tc.p); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	m := &metricHistory{}
+	rnd := rand.New(rand.NewSource(1))
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		m.add(v, rnd)
+	}
+
+	want := metricSummary{Min: 1, Avg: 3, Max: 5, P50: 3, P95: 4.8, P99: 4.96}
+	got := summarize(m)
+	if got.Min != want.Min || got.Avg != want.Avg || got.Max != want.Max || got.P50 != want.P50 ||
+		!approxEqual(got.P95, want.P95) || !approxEqual(got.P99, want.P99) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	if got := summarize(nil); got != (metricSummary{}) {
+		t.Fatalf("expected an empty summary, got %+v", got)
+	}
+}
+
+func TestMetricHistoryBounded(t *testing.T) {
+	m := &metricHistory{}
+	rnd := rand.New(rand.NewSource(1))
+	n := 5 * historyReservoirSize
+	for i := 0; i < n; i++ {
+		m.add(float64(i), rnd)
+	}
+
+	if len(m.reservoir) != historyReservoirSize {
+		t.Fatalf("expected %d values in the reservoir, got %d", historyReservoirSize, len(m.reservoir))
+	}
+	if m.min != 0 || m.max != float64(n-1) || m.count != n {
+		t.Fatalf("expected exact min, max and count, got %v, %v and %d", m.min, m.max, m.count)
+	}
+
+	// The reservoir is a uniform sample, so its median is close to the
+	// median of all the values.
+	got := summarize(m).P50
+	if want := float64(n) / 2; got < want*0.95 || got > want*1.05 {
+		t.Fatalf("expected a median close to %v, got %v", want, got)
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
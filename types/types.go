@@ -15,6 +15,8 @@ type Stats struct {
 	Blkio    Blkio              `json:"blkio"`
 	Hugetlb  map[string]Hugetlb `json:"hugetlb"`
 	IntelRdt IntelRdt           `json:"intel_rdt"`
+
+	NetworkInterfaces []*NetworkInterface `json:"network_interfaces,omitempty"`
 }
 
 // Hugetlb contains the huge pages stats.
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// webhookAttempts is how many times a notification is sent before giving
+	// up on it.
+	webhookAttempts = 5
+	// webhookBackoff is the wait before the first retry, doubled after every
+	// attempt.
+	webhookBackoff = time.Second
+	// webhookTimeout is the timeout of a single request.
+	webhookTimeout = 10 * time.Second
+	// webhookQueueSize is how many notifications can wait to be sent before
+	// new ones are dropped.
+	webhookQueueSize = 100
+	// webhookFlushTimeout is how long to wait for the queued notifications
+	// to be sent when exiting.
+	webhookFlushTimeout = 5 * time.Second
+	// webhookRepeatInterval is how often the alerts that are still firing are
+	// sent again to Alertmanager, which resolves the alerts that are not
+	// sent again before their end time.
+	webhookRepeatInterval = time.Minute
+	// webhookRepeatEnds is how many repeat intervals after it was sent a
+	// firing alert ends, so a lost request does not resolve it.
+	webhookRepeatEnds = 3
+)
+
+// webhookPresets are the built in payload templates.
+var webhookPresets = map[string]string{
+	"json":  `{{ json . }}`,
+	"slack": `{"text": {{ json (printf "[%s] %s on container %s on %s: value %.2f, threshold %.2f" .Status .Rule (or .Name .Container) .Hostname .Value .Threshold) }}}`,
+	"alertmanager": `[{"labels": {"alertname": {{ json .Rule }}, "container": {{ json .Container }}, "name": {{ json .Name }}, "instance": {{ json .Hostname }}, "job": "magneto"},
+"annotations": {"summary": {{ json (printf "%s is %s on container %s" .Rule .Status (or .Name .Container)) }}, "value": {{ json (printf "%.2f" .Value) }}, "threshold": {{ json (printf "%.2f" .Threshold) }}},
+"startsAt": {{ json .StartsAt }}{{ with .EndsAt }}, "endsAt": {{ json . }}{{ end }}}]`,
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// notification is the data the webhook payload templates are executed with.
+type notification struct {
+	ruleEvent
+	// Status is either firing or resolved.
+	Status   string    `json:"status"`
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt is when a resolved alert ended, or when a firing alert that is
+	// sent again ends unless it is sent again before.
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	Hostname string     `json:"hostname"`
+}
+
+// webhook sends the firing and resolved rule events to a URL.
+type webhook struct {
+	url    string
+	tmpl   *template.Template
+	client *http.Client
+	queue  chan notification
+	wg     sync.WaitGroup
+	// backoff is the wait before the first retry.
+	backoff time.Duration
+	// repeat is how often the firing alerts are sent again, 0 to send them
+	// once.
+	repeat time.Duration
+	done   chan struct{}
+
+	mu sync.Mutex
+	// closed is set once the queue is closed, later events are dropped.
+	closed    bool
+	closeOnce sync.Once
+	// firing holds the notifications of the rules that are firing, by
+	// container and rule, so each alert is only sent once, or again at the
+	// repeat interval, and resolved once.
+	firing map[string]notification
+}
+
+// parseWebhook parses a webhook in the format [preset=]url. Webhooks without
+// a preset use the template file if one is given, the json preset otherwise.
+func parseWebhook(s, templateFile string) (*webhook, error) {
+	var (
+		url    = s
+		preset = "json"
+		text   string
+	)
+	if i := strings.Index(s, "="); i > 0 && !strings.Contains(s[:i], "://") {
+		preset, url = s[:i], s[i+1:]
+	} else if templateFile != "" {
+		b, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		preset, text = templateFile, string(b)
+	}
+
+	if text == "" {
+		var ok bool
+		if text, ok = webhookPresets[preset]; !ok {
+			return nil, fmt.Errorf("unknown webhook preset %s, must be json, slack or alertmanager", preset)
+		}
+	}
+
+	tmpl, err := template.New(preset).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing webhook template %s failed: %v", preset, err)
+	}
+
+	w := &webhook{
+		url:     url,
+		tmpl:    tmpl,
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan notification, webhookQueueSize),
+		backoff: webhookBackoff,
+		done:    make(chan struct{}),
+		firing:  map[string]notification{},
+	}
+	// Alertmanager resolves the alerts that are not sent again, the other
+	// presets get each alert once.
+	if preset == "alertmanager" {
+		w.repeat = webhookRepeatInterval
+	}
+	return w, nil
+}
+
+// start starts sending the queued notifications, and the firing alerts again
+// at the repeat interval.
+func (w *webhook) start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for n := range w.queue {
+			w.send(n)
+		}
+	}()
+
+	if w.repeat <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(w.repeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.resend()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// resend queues the notifications of the alerts that are still firing again,
+// with a new end time.
+func (w *webhook) resend() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	for key, n := range w.firing {
+		n.EndsAt = w.endsAt()
+		w.enqueue(key, n)
+	}
+}
+
+// endsAt returns the end time of a firing alert that is sent again, nil if
+// the alerts are sent once.
+func (w *webhook) endsAt() *time.Time {
+	if w.repeat <= 0 {
+		return nil
+	}
+	t := time.Now().Add(webhookRepeatEnds * w.repeat)
+	return &t
+}
+
+// notify queues a notification for the rule event if a rule started firing or
+// resolved. Repeated firing events for a rule that is already firing are
+// dropped.
+func (w *webhook) notify(e ruleEvent) {
+	key := e.Container + "/" + e.Rule
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	// The notification is queued with the lock held, so the queue is not
+	// closed meanwhile.
+	defer w.mu.Unlock()
+
+	hostname, _ := os.Hostname()
+	n := notification{
+		ruleEvent: e,
+		Status:    e.State,
+		Hostname:  hostname,
+	}
+	prev, firing := w.firing[key]
+	switch e.State {
+	case ruleFiring:
+		if firing {
+			return
+		}
+		n.StartsAt = e.Time
+		// OOM kills do not resolve, so they are neither kept as firing nor
+		// sent again.
+		if e.Rule != ruleOOM {
+			n.EndsAt = w.endsAt()
+			w.firing[key] = n
+		}
+	case ruleResolved:
+		if !firing {
+			return
+		}
+		n.StartsAt = prev.StartsAt
+		endsAt := e.Time
+		n.EndsAt = &endsAt
+		delete(w.firing, key)
+	default:
+		return
+	}
+	w.enqueue(key, n)
+}
+
+// enqueue queues the notification, dropping it if the queue is full. It must
+// be called with the lock held.
+func (w *webhook) enqueue(key string, n notification) {
+	select {
+	case w.queue <- n:
+	default:
+		logrus.Errorf("webhook %s queue is full, dropping notification for %s", w.url, key)
+	}
+}
+
+// send posts the notification, retrying with backoff.
+func (w *webhook) send(n notification) {
+	var body bytes.Buffer
+	if err := w.tmpl.Execute(&body, n); err != nil {
+		logrus.Errorf("executing webhook template for %s failed: %v", w.url, err)
+		return
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(body.Bytes())
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= webhookAttempts {
+			logrus.Errorf("sending notification to webhook %s failed after %d attempts: %v", w.url, attempt, err)
+			return
+		}
+		logrus.Debugf("sending notification to webhook %s failed, retrying in %s: %v", w.url, backoff, err)
+		time.Sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+// post sends the payload and returns whether a failed request should be
+// retried.
+func (w *webhook) post(payload []byte) (bool, error) {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	// Retry on server errors and rate limiting, other client errors will fail
+	// the same way again.
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// close waits for the queued notifications to be sent, for at most the flush
+// timeout. The events notified after it was called are dropped.
+func (w *webhook) close() {
+	w.closeOnce.Do(w.flush)
+}
+
+func (w *webhook) flush() {
+	w.mu.Lock()
+	w.closed = true
+	close(w.done)
+	close(w.queue)
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookFlushTimeout):
+		logrus.Warnf("timed out sending the queued notifications to webhook %s", w.url)
+	}
+}
+
+// addWebhooks sends the rule events to the webhooks.
+func (s *stats) addWebhooks(urls []string, templateFile string) error {
+	for _, u := range urls {
+		w, err := parseWebhook(u, templateFile)
+		if err != nil {
+			return err
+		}
+		w.start()
+		s.ruleListeners = append(s.ruleListeners, w.notify)
+		s.closers = append(s.closers, w.close)
+	}
+	return nil
+}
+
+// stringsFlag collects the values of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookCloseDropsEvents(t *testing.T) {
+	var posted int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posted, 1)
+	}))
+	defer srv.Close()
+
+	w, err := parseWebhook(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.start()
+
+	firing := func(i int) ruleEvent {
+		return ruleEvent{Time: time.Unix(1000, 0), Container: "abc", Rule: string(rune('a' + i%26)), State: ruleFiring}
+	}
+	w.notify(firing(0))
+
+	// Closing while events are notified must neither panic nor block, and
+	// closing again is a no-op.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.notify(firing(i))
+		}(i)
+	}
+	w.close()
+	w.close()
+	wg.Wait()
+
+	before := atomic.LoadInt32(&posted)
+	if before < 1 {
+		t.Fatalf("expected the event notified before closing to be sent")
+	}
+	w.notify(firing(20))
+	if got := atomic.LoadInt32(&posted); got != before {
+		t.Fatalf("expected no notification after closing, got %d", got-before)
+	}
+}
+
+// webhookServer records the payloads posted to it, answering with the given
+// statuses in order and 200 once they are used up.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	payloads [][]byte
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.payloads = append(s.payloads, b)
+		if len(s.statuses) > 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	return s
+}
+
+func (s *webhookServer) posted() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.payloads...)
+}
+
+func TestWebhookRetry(t *testing.T) {
+	testCases := []struct {
+		name     string
+		statuses []int
+		expected int
+	}{
+		{name: "success", expected: 1},
+		{name: "server errors", statuses: []int{500, 503}, expected: 3},
+		{name: "rate limited", statuses: []int{429}, expected: 2},
+		{name: "client error", statuses: []int{400}, expected: 1},
+		{name: "gives up", statuses: []int{500, 500, 500, 500, 500, 500}, expected: webhookAttempts},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newWebhookServer(tc.statuses...)
+			defer srv.Close()
+
+			w, err := parseWebhook(srv.URL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.backoff = time.Millisecond
+			w.start()
+			w.notify(ruleEvent{Time: time.Unix(1000, 0), Container: "abc", Rule: "cpu > 90", State: ruleFiring})
+			w.close()
+
+			if got := len(srv.posted()); got != tc.expected {
+				t.Fatalf("expected %d attempts, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestWebhookDedup(t *testing.T) {
+	srv := newWebhookServer()
+	defer srv.Close()
+
+	w, err := parseWebhook(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.start()
+
+	event := func(sec int64, container, rule, state string) ruleEvent {
+		return ruleEvent{Time: time.Unix(sec, 0), Container: container, Rule: rule, State: state}
+	}
+	for _, e := range []ruleEvent{
+		// Resolving a rule that is not firing is dropped.
+		event(1000, "abc", "cpu > 90", ruleResolved),
+		event(1001, "abc", "cpu > 90", ruleFiring),
+		event(1002, "abc", "cpu > 90", ruleFiring),
+		event(1003, "def", "cpu > 90", ruleFiring),
+		event(1004, "abc", "cpu > 90", ruleResolved),
+		event(1005, "abc", "cpu > 90", ruleResolved),
+		// OOM kills are sent every time.
+		event(1006, "abc", ruleOOM, ruleFiring),
+		event(1007, "abc", ruleOOM, ruleFiring),
+		event(1008, "abc", "cpu > 90", ruleFiring),
+	} {
+		w.notify(e)
+	}
+	w.close()
+
+	var got []string
+	for _, b := range srv.posted() {
+		var n struct {
+			Time      time.Time `json:"time"`
+			Container string    `json:"container"`
+			Status    string    `json:"status"`
+			StartsAt  time.Time `json:"starts_at"`
+		}
+		if err := json.Unmarshal(b, &n); err != nil {
+			t.Fatalf("invalid payload %s: %v", b, err)
+		}
+		got = append(got, fmt.Sprintf("%d %s %s %d", n.Time.Unix(), n.Container, n.Status, n.StartsAt.Unix()))
+	}
+	expected := []string{
+		"1001 abc firing 1001",
+		"1003 def firing 1003",
+		"1004 abc resolved 1001",
+		"1006 abc firing 1006",
+		"1007 abc firing 1007",
+		"1008 abc firing 1008",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected notifications %v, got %v", expected, got)
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	testCases := []struct {
+		preset   string
+		state    string
+		expected string
+	}{
+		{
+			preset:   "json",
+			state:    ruleFiring,
+			expected: `{"time":"1970-01-01T00:16:41Z","container":"abc","name":"web","rule":"cpu \u003e 90","state":"firing","previous":"pending","value":95.5,"threshold":90,"status":"firing","starts_at":"1970-01-01T00:16:41Z","hostname":"HOST"}`,
+		},
+		{
+			preset:   "json",
+			state:    ruleResolved,
+			expected: `{"time":"1970-01-01T00:16:42Z","container":"abc","name":"web","rule":"cpu \u003e 90","state":"resolved","previous":"pending","value":95.5,"threshold":90,"status":"resolved","starts_at":"1970-01-01T00:16:41Z","ends_at":"1970-01-01T00:16:42Z","hostname":"HOST"}`,
+		},
+		{
+			preset:   "slack",
+			state:    ruleFiring,
+			expected: `{"text":"[firing] cpu \u003e 90 on container web on HOST: value 95.50, threshold 90.00"}`,
+		},
+		{
+			preset:   "alertmanager",
+			state:    ruleResolved,
+			expected: `[{"labels":{"alertname":"cpu \u003e 90","container":"abc","name":"web","instance":"HOST","job":"magneto"},"annotations":{"summary":"cpu \u003e 90 is resolved on container web","value":"95.50","threshold":"90.00"},"startsAt":"1970-01-01T00:16:41Z","endsAt":"1970-01-01T00:16:42Z"}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.preset+" "+tc.state, func(t *testing.T) {
+			srv := newWebhookServer()
+			defer srv.Close()
+
+			w, err := parseWebhook(tc.preset+"="+srv.URL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.start()
+			e := ruleEvent{Time: time.Unix(1001, 0).UTC(), Container: "abc", Name: "web", Rule: "cpu > 90", State: ruleFiring, Previous: "pending", Value: 95.5, Threshold: 90}
+			w.notify(e)
+			if tc.state == ruleResolved {
+				e.Time, e.State = time.Unix(1002, 0).UTC(), ruleResolved
+				w.notify(e)
+			}
+			w.close()
+
+			posted := srv.posted()
+			if len(posted) == 0 {
+				t.Fatal("expected a notification")
+			}
+			// Compact the payload, the presets are not formatted the same.
+			var got bytes.Buffer
+			if err := json.Compact(&got, posted[len(posted)-1]); err != nil {
+				t.Fatalf("invalid payload %s: %v", posted[len(posted)-1], err)
+			}
+			expected := strings.Replace(tc.expected, "HOST", hostname, -1)
+			if got.String() != expected {
+				t.Fatalf("expected payload\n%s\ngot\n%s", expected, got.String())
+			}
+		})
+	}
+}
+
+func TestWebhookAlertmanagerRepeat(t *testing.T) {
+	srv := newWebhookServer()
+	defer srv.Close()
+
+	w, err := parseWebhook("alertmanager="+srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.repeat != webhookRepeatInterval {
+		t.Fatalf("expected alertmanager alerts to be sent again every %s, got %s", webhookRepeatInterval, w.repeat)
+	}
+	w.repeat = 10 * time.Millisecond
+	w.start()
+	defer w.close()
+
+	type alert struct {
+		StartsAt time.Time  `json:"startsAt"`
+		EndsAt   *time.Time `json:"endsAt"`
+	}
+	alerts := func() []alert {
+		var alerts []alert
+		for _, b := range srv.posted() {
+			var a []alert
+			if err := json.Unmarshal(b, &a); err != nil || len(a) != 1 {
+				t.Fatalf("invalid payload %s: %v", b, err)
+			}
+			alerts = append(alerts, a[0])
+		}
+		return alerts
+	}
+
+	e := ruleEvent{Time: time.Unix(1001, 0), Container: "abc", Rule: "cpu > 90", State: ruleFiring}
+	w.notify(e)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.posted()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	firing := alerts()
+	if len(firing) < 3 {
+		t.Fatalf("expected the firing alert to be sent again, got %d notifications", len(firing))
+	}
+	for i, a := range firing {
+		if !a.StartsAt.Equal(e.Time) {
+			t.Fatalf("expected the alert to start at %s, got %s", e.Time, a.StartsAt)
+		}
+		// A firing alert ends later every time it is sent again.
+		if a.EndsAt == nil || (i > 0 && !a.EndsAt.After(*firing[i-1].EndsAt)) {
+			t.Fatalf("expected the firing alert to end later when sent again, got %v", a.EndsAt)
+		}
+	}
+
+	e.Time, e.State = time.Unix(1002, 0), ruleResolved
+	w.notify(e)
+	time.Sleep(50 * time.Millisecond)
+	resolved := alerts()
+	last := resolved[len(resolved)-1]
+	if last.EndsAt == nil || !last.EndsAt.Equal(e.Time) {
+		t.Fatalf("expected the last alert to end at %s, got %v", e.Time, last.EndsAt)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := len(alerts()); got != len(resolved) {
+		t.Fatalf("expected a resolved alert not to be sent again, got %d more notifications", got-len(resolved))
+	}
+}